@@ -0,0 +1,93 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTooManyTransactions is returned by WithTransaction when no slot freed up
+// within the wait timeout of MaxConcurrent
+var ErrTooManyTransactions = errors.New("txmanager: too many concurrent transactions")
+
+// MaxConcurrent is a manager option allowing at most n top-level transactions
+// to run concurrently, each holding a pooled connection. Callers wait in FIFO
+// order for at most wait then get ErrTooManyTransactions. A zero wait waits
+// until their ctx is done, then they get the ctx error.
+func MaxConcurrent(n int, wait time.Duration) Option {
+	return func(c *config) {
+		c.maxConcurrent = n
+		c.maxWait = wait
+	}
+}
+
+// MaxConcurrentNamed is a manager option allowing at most n top-level
+// transactions with the given Name to run concurrently, on top of
+// MaxConcurrent, so that batch jobs can be capped apart from user requests.
+// They wait as configured by MaxConcurrent.
+func MaxConcurrentNamed(name string, n int) Option {
+	return func(c *config) {
+		if c.nameQuotas == nil {
+			c.nameQuotas = map[string]int{}
+		}
+		c.nameQuotas[name] = n
+	}
+}
+
+// admission holds the slots of a manager configured with MaxConcurrent
+type admission struct {
+	wait  time.Duration
+	total chan struct{}
+	names map[string]chan struct{}
+}
+
+func newAdmission(cfg config) *admission {
+	a := &admission{wait: cfg.maxWait, names: map[string]chan struct{}{}}
+	if cfg.maxConcurrent > 0 {
+		a.total = make(chan struct{}, cfg.maxConcurrent)
+	}
+	for name, n := range cfg.nameQuotas {
+		a.names[name] = make(chan struct{}, n)
+	}
+	return a
+}
+
+// acquire takes a slot for a transaction named name, the returned func
+// releases it
+func (a *admission) acquire(ctx context.Context, name string) (release func(), err error) {
+	named := a.names[name]
+	if a.total == nil && named == nil {
+		return func() {}, nil
+	}
+
+	var timeout <-chan time.Time
+	if a.wait > 0 {
+		timer := time.NewTimer(a.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// the named slot first, so that a capped name waits without holding a slot
+	// of the others
+	var held []chan struct{}
+	release = func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+	for _, slots := range []chan struct{}{named, a.total} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			held = append(held, slots)
+		case <-timeout:
+			release()
+			return nil, ErrTooManyTransactions
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,114 @@
+package txmanager_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// holdTransactions starts n transactions on txManager blocked until the
+// returned func is called, which waits for them to end
+func holdTransactions(t *testing.T, txManager txmanager.TxManager, n int, opts ...txmanager.Option) func() {
+	began := make(chan struct{}, n)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				began <- struct{}{}
+				<-release
+				return nil
+			}, opts...)
+			require.NoError(t, err)
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-began
+	}
+	return func() {
+		close(release)
+		wg.Wait()
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	nop := func(context.Context) error { return nil }
+
+	t.Run("WaitTimeout", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(nopDriver{}, txmanager.MaxConcurrent(2, 20*time.Millisecond))
+		release := holdTransactions(t, txManager, 2)
+
+		require.Equal(t, txmanager.ErrTooManyTransactions, txManager.WithTransaction(context.Background(), nop))
+		release()
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+	})
+
+	t.Run("WaitsForSlot", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(nopDriver{}, txmanager.MaxConcurrent(1, time.Second))
+		release := holdTransactions(t, txManager, 1)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			release()
+		}()
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+	})
+
+	t.Run("ContextDone", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(nopDriver{}, txmanager.MaxConcurrent(1, 0))
+		release := holdTransactions(t, txManager, 1)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.Equal(t, context.DeadlineExceeded, txManager.WithTransaction(ctx, nop))
+	})
+
+	t.Run("NestedDoNotTakeSlot", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.MaxConcurrent(1, 20*time.Millisecond))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txManager.WithTransaction(ctx, nop, txmanager.Nested())
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("InOtherManagerTransaction", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(&flakyDriver{}, txmanager.MaxConcurrent(1, 20*time.Millisecond))
+		release := holdTransactions(t, txManager, 1)
+		defer release()
+
+		// a transaction of another database is not joined, it takes a slot
+		err := txmanager.NewTxManager(&flakyDriver{}).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txManager.WithTransaction(ctx, nop)
+		})
+		require.Equal(t, txmanager.ErrTooManyTransactions, err)
+	})
+
+	t.Run("SessionDoNotTakeSlot", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.MaxConcurrent(1, 20*time.Millisecond))
+		err := txManager.(txmanager.SessionManager).WithSession(context.Background(), func(ctx context.Context) error {
+			return txManager.WithTransaction(ctx, nop)
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("NamedQuota", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(nopDriver{},
+			txmanager.MaxConcurrent(3, 20*time.Millisecond),
+			txmanager.MaxConcurrentNamed("batch", 1))
+		release := holdTransactions(t, txManager, 1, txmanager.Name("batch"))
+		defer release()
+
+		err := txManager.WithTransaction(context.Background(), nop, txmanager.Name("batch"))
+		require.Equal(t, txmanager.ErrTooManyTransactions, err)
+		// user requests still get the remaining slots
+		releaseUsers := holdTransactions(t, txManager, 2, txmanager.Name("user"))
+		defer releaseUsers()
+	})
+}
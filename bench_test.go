@@ -0,0 +1,116 @@
+package txmanager_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+	gormv2 "gorm.io/gorm"
+)
+
+// nopDriver begins transactions doing nothing, to measure the manager overhead alone
+type nopDriver struct{}
+
+func (nopDriver) Begin(ctx context.Context, _ *sql.TxOptions) (context.Context, txmanager.Tx, error) {
+	return ctx, nopTx{}, nil
+}
+
+type nopTx struct{}
+
+func (nopTx) Commit() error   { return nil }
+func (nopTx) Rollback() error { return nil }
+
+func TestWithTransactionWaitsForTxFn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	finished := false
+	err := txmanager.NewTxManager(nopDriver{}).WithTransaction(ctx, func(ctx context.Context) error {
+		cancel()
+		<-ctx.Done()
+		finished = true
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled))
+	require.True(t, finished, "WithTransaction returned before the TxFn")
+}
+
+func BenchmarkWithTransaction(b *testing.B) {
+	ctx := context.Background()
+	nop := func(context.Context) error { return nil }
+
+	b.Run("Overhead", func(b *testing.B) {
+		txManager := txmanager.NewTxManager(nopDriver{})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := txManager.WithTransaction(ctx, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SQLite", func(b *testing.B) {
+		txManager := txmanager.StartTxManager(getSqlite(b))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := txManager.WithTransaction(ctx, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SQLiteBare", func(b *testing.B) {
+		db := getSqlite(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.Transaction(func(*gorm.DB) error { return nil }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SQLiteV2", func(b *testing.B) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(b))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := txManager.WithTransaction(ctx, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SQLiteV2Bare", func(b *testing.B) {
+		db := getSqliteV2(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.WithContext(ctx).Transaction(func(*gormv2.DB) error { return nil }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Nested", func(b *testing.B) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(b))
+		b.ReportAllocs()
+		b.ResetTimer()
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			for i := 0; i < b.N; i++ {
+				if err := txManager.WithTransaction(ctx, nop, txmanager.Nested()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned by WithTransaction, without trying to begin, while
+// the circuit breaker of the manager is open
+var ErrCircuitOpen = errors.New("txmanager: circuit open, database unavailable")
+
+// CircuitState is the state of the circuit breaker of a manager
+type CircuitState int
+
+const (
+	// CircuitClosed lets every transaction through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every transaction fast with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen lets a single transaction through to probe the database
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitListener can be implemented by a TxListener registered on the manager
+// to be notified when its circuit breaker changes state
+type CircuitListener interface {
+	OnCircuitChange(from, to CircuitState)
+}
+
+// CircuitBreaker is a manager option opening the circuit after failures
+// consecutive begin or commit failures. While open, transactions fail fast with
+// ErrCircuitOpen. After openFor a single transaction probes the database, the
+// circuit closes if it succeeds and opens again otherwise. State changes are
+// logged and notified to the listeners implementing CircuitListener.
+func CircuitBreaker(failures int, openFor time.Duration) Option {
+	return func(c *config) {
+		c.breaker = &breaker{failures: failures, openFor: openFor}
+	}
+}
+
+type breaker struct {
+	failures int
+	openFor  time.Duration
+
+	mu          sync.Mutex
+	state       CircuitState
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+}
+
+// allow reports whether a top-level transaction may begin and whether it is
+// the probe of a half-open circuit
+func (b *breaker) allow(ls listeners) (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case b.state == CircuitOpen && time.Since(b.openedAt) >= b.openFor:
+		b.state = CircuitHalfOpen
+		b.probing = true
+	case b.state == CircuitOpen || b.state == CircuitHalfOpen && b.probing:
+		b.mu.Unlock()
+		return false, ErrCircuitOpen
+	case b.state == CircuitHalfOpen:
+		b.probing = true
+	}
+	to, probe := b.state, b.probing
+	b.mu.Unlock()
+
+	notifyCircuit(ls, from, to)
+	return probe, nil
+}
+
+// done reports the outcome of a transaction let through by allow, err being
+// its begin or commit error. Only the probe changes a half-open circuit, the
+// transactions admitted before the circuit opened only count while it is
+// closed. Errors caused by ctx are not held against the database.
+func (b *breaker) done(ctx context.Context, probe bool, err error, ls listeners) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	from := b.state
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case !probe && b.state != CircuitClosed:
+	case err != nil && ctx.Err() != nil:
+		// unknown outcome, a half-open circuit lets another probe through
+	case err != nil:
+		b.consecutive++
+		if probe || b.consecutive >= b.failures {
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+		}
+	default:
+		b.consecutive = 0
+		b.state = CircuitClosed
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	notifyCircuit(ls, from, to)
+}
+
+func notifyCircuit(ls listeners, from, to CircuitState) {
+	if from == to {
+		return
+	}
+	logrus.Warnf("txmanager: circuit breaker %s -> %s", from, to)
+	for _, l := range ls {
+		if cl, ok := l.(CircuitListener); ok {
+			cl.OnCircuitChange(from, to)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package txmanager_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("database down")
+
+// flakyDriver fails to begin while down
+type flakyDriver struct {
+	mu     sync.Mutex
+	down   bool
+	begins int
+}
+
+func (d *flakyDriver) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = down
+}
+
+func (d *flakyDriver) Begin(ctx context.Context, _ *sql.TxOptions) (context.Context, txmanager.Tx, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.begins++
+	if d.down {
+		return nil, nil, errDown
+	}
+	return ctx, nopTx{}, nil
+}
+
+type circuitListener struct {
+	txmanager.NopTxListener
+	changes []string
+}
+
+func (l *circuitListener) OnCircuitChange(from, to txmanager.CircuitState) {
+	l.changes = append(l.changes, from.String()+" -> "+to.String())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	nop := func(context.Context) error { return nil }
+
+	t.Run("OpensThenProbes", func(t *testing.T) {
+		driver := &flakyDriver{down: true}
+		l := &circuitListener{}
+		txManager := txmanager.NewTxManager(driver, txmanager.CircuitBreaker(3, 20*time.Millisecond), txmanager.WithListeners(l))
+
+		for i := 0; i < 3; i++ {
+			require.Equal(t, errDown, txManager.WithTransaction(context.Background(), nop))
+		}
+		require.Equal(t, txmanager.ErrCircuitOpen, txManager.WithTransaction(context.Background(), nop))
+		require.Equal(t, 3, driver.begins)
+
+		// the probe fails, the circuit opens again
+		time.Sleep(20 * time.Millisecond)
+		require.Equal(t, errDown, txManager.WithTransaction(context.Background(), nop))
+		require.Equal(t, txmanager.ErrCircuitOpen, txManager.WithTransaction(context.Background(), nop))
+
+		// the probe succeeds, the circuit closes
+		driver.setDown(false)
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+
+		require.Equal(t, []string{
+			"closed -> open",
+			"open -> half-open",
+			"half-open -> open",
+			"open -> half-open",
+			"half-open -> closed",
+		}, l.changes)
+	})
+
+	t.Run("SingleProbe", func(t *testing.T) {
+		driver := &flakyDriver{down: true}
+		txManager := txmanager.NewTxManager(driver, txmanager.CircuitBreaker(1, time.Millisecond))
+		require.Equal(t, errDown, txManager.WithTransaction(context.Background(), nop))
+		driver.setDown(false)
+		time.Sleep(time.Millisecond)
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			// the circuit is half-open while the probe runs
+			return txManager.WithTransaction(context.Background(), nop)
+		})
+		require.Equal(t, txmanager.ErrCircuitOpen, err)
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+	})
+
+	t.Run("OnlyProbeChangesHalfOpen", func(t *testing.T) {
+		driver := &flakyDriver{}
+		l := &circuitListener{}
+		txManager := txmanager.NewTxManager(driver, txmanager.CircuitBreaker(1, time.Millisecond), txmanager.WithListeners(l))
+
+		// a transaction admitted while closed outlives the opening of the circuit
+		began := make(chan struct{})
+		finish := make(chan struct{})
+		slowErr := make(chan error, 1)
+		go func() {
+			slowErr <- txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				close(began)
+				<-finish
+				return nil
+			})
+		}()
+		<-began
+
+		driver.setDown(true)
+		require.Equal(t, errDown, txManager.WithTransaction(context.Background(), nop))
+		driver.setDown(false)
+		time.Sleep(time.Millisecond)
+
+		// the slow transaction commits while the probe runs
+		probeErr := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			close(finish)
+			require.NoError(t, <-slowErr)
+			// the probe is still running, the circuit stays half-open
+			return txManager.WithTransaction(context.Background(), nop)
+		})
+		require.Equal(t, txmanager.ErrCircuitOpen, probeErr)
+
+		require.Equal(t, []string{
+			"closed -> open",
+			"open -> half-open",
+			"half-open -> closed",
+		}, l.changes)
+	})
+
+	t.Run("TxFnErrorsDoNotCount", func(t *testing.T) {
+		txManager := txmanager.NewTxManager(&flakyDriver{}, txmanager.CircuitBreaker(1, time.Hour))
+		for i := 0; i < 3; i++ {
+			err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				return errDown
+			})
+			require.Equal(t, errDown, err)
+		}
+		require.NoError(t, txManager.WithTransaction(context.Background(), nop))
+	})
+}
@@ -0,0 +1,116 @@
+package txmanager_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+	sqlitev2 "gorm.io/driver/sqlite"
+	gormv2 "gorm.io/gorm"
+)
+
+type record struct {
+	Key string `gorm:"primary_key;primaryKey"`
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		txmanagertest.RunConformanceGorm(t, getSqlite(t), nil)
+	})
+
+	t.Run("SQLiteV2", func(t *testing.T) {
+		txmanagertest.RunConformanceGormV2(t, getSqliteV2(t), nil)
+	})
+
+	t.Run("MySQL", func(t *testing.T) {
+		skipWithoutMysql(t)
+		db := getMysql()
+		defer closeDB(t, db)
+		txmanagertest.RunConformanceGorm(t, db, nil)
+	})
+
+	t.Run("MySQLV2", func(t *testing.T) {
+		skipWithoutMysql(t)
+		db := getMysqlV2()
+		defer closeDBV2(t, db)
+		txmanagertest.RunConformanceGormV2(t, db, nil)
+	})
+
+	t.Run("SchemaSetup", func(t *testing.T) {
+		var setups int
+		txmanagertest.RunConformanceGormV2(t, getSqliteV2(t), func(db *gormv2.DB) error {
+			setups++
+			return db.Exec("CREATE TABLE txmanagertest_records (key TEXT PRIMARY KEY)").Error
+		})
+		require.True(t, setups > 1)
+	})
+}
+
+func gormBackend(t *testing.T, db *gorm.DB, opts ...txmanager.Option) txmanagertest.Backend {
+	require.NoError(t, db.AutoMigrate(&record{}).Error)
+	return txmanagertest.Backend{
+		Manager: txmanager.StartTxManager(db, opts...),
+		Insert: func(ctx context.Context, key string) error {
+			return txmanager.GetTxConn(ctx).Create(&record{Key: key}).Error
+		},
+		Exists: func(key string) (bool, error) {
+			var count int
+			err := db.Model(&record{}).Where("`key` = ?", key).Count(&count).Error
+			return count > 0, err
+		},
+	}
+}
+
+func gormV2Backend(t *testing.T, db *gormv2.DB, opts ...txmanager.Option) txmanagertest.Backend {
+	require.NoError(t, db.AutoMigrate(&record{}))
+	return txmanagertest.Backend{
+		Manager: txmanager.NewGormTxManager(db, opts...),
+		Insert: func(ctx context.Context, key string) error {
+			return txmanager.GetTxConnV2(ctx).Create(&record{Key: key}).Error
+		},
+		Exists: func(key string) (bool, error) {
+			var count int64
+			err := db.Model(&record{}).Where("`key` = ?", key).Count(&count).Error
+			return count > 0, err
+		},
+	}
+}
+
+// getSqlite opens a SQLite database in a temporary directory, closed when t ends
+func getSqlite(t testing.TB) *gorm.DB {
+	db, err := gorm.Open("sqlite3", sqliteDSN(t))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+	return db
+}
+
+// getSqliteV2 opens a SQLite database in a temporary directory, closed when t ends
+func getSqliteV2(t testing.TB) *gormv2.DB {
+	db, err := gormv2.Open(sqlitev2.Open(sqliteDSN(t)), &gormv2.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	})
+	return db
+}
+
+func sqliteDSN(t testing.TB) string {
+	// WAL lets reads outside of a transaction run while it holds the write lock
+	return filepath.Join(t.TempDir(), "txmanager.db") + "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+}
+
+func skipWithoutMysql(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set, start the test mysql with `make test-infra-up`")
+	}
+}
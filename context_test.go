@@ -0,0 +1,189 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// slowQuery counts up to a billion, it runs far longer than the tests deadlines
+const slowQuery = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT count(*) FROM c"
+
+func TestContextBinding(t *testing.T) {
+	t.Run("DeadlineAbortsStatementV2", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			var count int64
+			return txmanager.GetTxConnV2(ctx).Raw(slowQuery).Row().Scan(&count)
+		})
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 5*time.Second, "the statement was not aborted")
+	})
+
+	t.Run("TxFnContextAbortsStatementV2", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			stmtCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			var count int64
+			err := txmanager.GetTxConnV2(stmtCtx).Raw(slowQuery).Row().Scan(&count)
+			require.Error(t, err)
+
+			// the transaction itself is still usable
+			return txmanager.GetTxConnV2(ctx).Raw("SELECT 1").Scan(&count).Error
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("DeadlineAbortsStatement", func(t *testing.T) {
+		txManager := txmanager.StartTxManager(getSqlite(t))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			var count int64
+			return txmanager.GetTxConn(ctx).Raw(slowQuery).Row().Scan(&count)
+		})
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 5*time.Second, "the statement was not aborted")
+	})
+
+	t.Run("TxFnContextAbortsStatement", func(t *testing.T) {
+		b := gormBackend(t, getSqlite(t), txmanager.CaptureStatements())
+		rec := &txmanager.Recorder{}
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			stmtCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			var count int64
+			err := txmanager.GetTxConn(stmtCtx).Raw(slowQuery).Row().Scan(&count)
+			require.Error(t, err)
+
+			// the transaction itself is still usable, and its statements reported
+			return b.Insert(ctx, "a")
+		}, txmanager.Record(rec))
+		require.NoError(t, err)
+		require.Contains(t, rec.Transcript(), "INSERT INTO")
+		exists, err := b.Exists("a")
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("KeepsScopes", func(t *testing.T) {
+		db := getSqlite(t)
+		require.NoError(t, db.AutoMigrate(&softRecord{}).Error)
+		require.NoError(t, db.Create(&softRecord{Key: "a"}).Error)
+		require.NoError(t, db.Delete(&softRecord{Key: "a"}).Error)
+		txManager := txmanager.StartTxManager(db.Unscoped())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			stmtCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+
+			// the handle bound to stmtCtx is still unscoped, and aborted with it
+			var count int
+			require.NoError(t, txmanager.GetTxConn(stmtCtx).Model(&softRecord{}).Count(&count).Error)
+			require.Equal(t, 1, count)
+			require.Error(t, txmanager.GetTxConn(stmtCtx).Raw(slowQuery).Row().Scan(&count))
+			return nil
+		})
+		require.NoError(t, err)
+
+		// so is the handle of a session
+		err = txManager.(txmanager.SessionManager).WithSession(context.Background(), func(ctx context.Context) error {
+			var count int
+			require.NoError(t, txmanager.GetTxConn(ctx).Model(&softRecord{}).Count(&count).Error)
+			require.Equal(t, 1, count)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("CancelRollsBack", func(t *testing.T) {
+		b := gormBackend(t, getSqlite(t))
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := b.Manager.WithTransaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, b.Insert(ctx, "a"))
+			cancel()
+			return nil
+		})
+		require.True(t, errors.Is(err, context.Canceled))
+		exists, err := b.Exists("a")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
+
+func TestMaxDuration(t *testing.T) {
+	t.Run("DeadlineError", func(t *testing.T) {
+		b := gormV2Backend(t, getSqliteV2(t))
+		l := &recordingListener{}
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, b.Insert(ctx, "a"))
+			<-ctx.Done()
+			return nil
+		}, txmanager.MaxDuration(50*time.Millisecond), txmanager.Name("slow"), txmanager.WithListeners(l))
+
+		var de *txmanager.DeadlineError
+		require.True(t, errors.As(err, &de), "expected *txmanager.DeadlineError, got %v", err)
+		require.Equal(t, "slow", de.Name)
+		require.Equal(t, 50*time.Millisecond, de.MaxDuration)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Contains(t, err.Error(), "exceeded its max duration")
+		require.Equal(t, []string{"begin", "cancel", "rollback"}, l.Events())
+
+		exists, err := b.Exists("a")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("AbortsStatementV2", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.MaxDuration(100*time.Millisecond))
+
+		start := time.Now()
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			var count int64
+			return txmanager.GetTxConnV2(ctx).Raw(slowQuery).Row().Scan(&count)
+		})
+		var de *txmanager.DeadlineError
+		require.True(t, errors.As(err, &de), "expected *txmanager.DeadlineError, got %v", err)
+		require.True(t, time.Since(start) < 5*time.Second, "the statement was not aborted")
+	})
+
+	t.Run("CallerDeadlineNotWrapped", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.MaxDuration(time.Hour))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		var de *txmanager.DeadlineError
+		require.False(t, errors.As(err, &de))
+		require.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("WithinDuration", func(t *testing.T) {
+		b := gormBackend(t, getSqlite(t))
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return b.Insert(ctx, "a")
+		}, txmanager.MaxDuration(time.Minute))
+		require.NoError(t, err)
+		exists, err := b.Exists("a")
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+}
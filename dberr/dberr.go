@@ -0,0 +1,233 @@
+// Package dberr classifies database errors returned by MySQL, Postgres and
+// SQLite, so that retry policies, HTTP mappings and alerts do not match on
+// err.Error().
+//
+// It recognises go-sql-driver/mysql errors, Postgres errors implementing
+// SQLState() string such as pgconn.PgError and pq.Error, and SQLite errors of
+// mattn/go-sqlite3 and modernc.org/sqlite, wrapped or not, including the errors
+// accumulated by gorm v1.
+package dberr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// class lists the codes of a kind of failure on every database
+type class struct {
+	// mysql are MySQL error numbers
+	mysql []uint16
+	// sqlstate are Postgres SQLSTATE codes, or classes when two characters long
+	sqlstate []string
+	// sqlite are SQLite primary result codes, matching all their extended codes
+	sqlite []int
+	// sqliteExtended are SQLite extended result codes
+	sqliteExtended []int
+	// errs are sentinel errors
+	errs []error
+	// match reports other errors of the class
+	match func(err error) bool
+}
+
+var (
+	deadlock = class{
+		mysql:    []uint16{1213},
+		sqlstate: []string{"40P01"},
+	}
+	lockTimeout = class{
+		mysql:    []uint16{1205},
+		sqlstate: []string{"55P03"},
+		// SQLITE_BUSY, SQLITE_BUSY_RECOVERY, SQLITE_BUSY_TIMEOUT, SQLITE_LOCKED,
+		// SQLITE_LOCKED_SHAREDCACHE but not SQLITE_BUSY_SNAPSHOT
+		sqliteExtended: []int{5, 261, 773, 6, 262},
+	}
+	serializationFailure = class{
+		sqlstate: []string{"40001"},
+		// SQLITE_BUSY_SNAPSHOT
+		sqliteExtended: []int{517},
+	}
+	uniqueViolation = class{
+		mysql:    []uint16{1062, 1586},
+		sqlstate: []string{"23505"},
+		// SQLITE_CONSTRAINT_PRIMARYKEY, SQLITE_CONSTRAINT_UNIQUE
+		sqliteExtended: []int{1555, 2067},
+	}
+	foreignKeyViolation = class{
+		mysql:    []uint16{1216, 1217, 1451, 1452},
+		sqlstate: []string{"23503"},
+		// SQLITE_CONSTRAINT_FOREIGNKEY
+		sqliteExtended: []int{787},
+	}
+	connectionLost = class{
+		// server shutdown, connection killed
+		mysql: []uint16{1053, 1927},
+		// connection exceptions, admin or crash shutdown, cannot connect now
+		sqlstate: []string{"08", "57P01", "57P02", "57P03"},
+		errs: []error{
+			driver.ErrBadConn, mysql.ErrInvalidConn, sql.ErrConnDone, io.ErrUnexpectedEOF,
+			syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE,
+		},
+		match: func(err error) bool {
+			_, ok := err.(*net.OpError)
+			return ok
+		},
+	}
+	readOnlyViolation = class{
+		// --read-only server, read-only transaction
+		mysql:    []uint16{1290, 1792, 1836},
+		sqlstate: []string{"25006"},
+		// SQLITE_READONLY
+		sqlite: []int{8},
+	}
+)
+
+// IsDeadlock reports whether the transaction was chosen as a deadlock victim
+func IsDeadlock(err error) bool {
+	return deadlock.is(err)
+}
+
+// IsLockTimeout reports whether a lock could not be acquired in time
+func IsLockTimeout(err error) bool {
+	return lockTimeout.is(err)
+}
+
+// IsSerializationFailure reports whether the transaction could not be
+// serialized with concurrent ones, and is worth retrying
+func IsSerializationFailure(err error) bool {
+	return serializationFailure.is(err)
+}
+
+// IsUniqueViolation reports whether a unique or primary key constraint was violated
+func IsUniqueViolation(err error) bool {
+	return uniqueViolation.is(err)
+}
+
+// IsForeignKeyViolation reports whether a foreign key constraint was violated
+func IsForeignKeyViolation(err error) bool {
+	return foreignKeyViolation.is(err)
+}
+
+// IsConnectionLost reports whether the connection to the database broke, the
+// outcome of a commit failing with it is unknown
+func IsConnectionLost(err error) bool {
+	return connectionLost.is(err)
+}
+
+// IsReadOnlyViolation reports whether a write was attempted on a read-only
+// database or transaction
+func IsReadOnlyViolation(err error) bool {
+	return readOnlyViolation.is(err)
+}
+
+// is reports whether err, or any error it wraps, belongs to c
+func (c class) is(err error) bool {
+	return walk(err, c.matches)
+}
+
+func (c class) matches(err error) bool {
+	for _, target := range c.errs {
+		if err == target {
+			return true
+		}
+	}
+	if c.match != nil && c.match(err) {
+		return true
+	}
+
+	if me, ok := err.(*mysql.MySQLError); ok {
+		for _, n := range c.mysql {
+			if me.Number == n {
+				return true
+			}
+		}
+		return false
+	}
+
+	if se, ok := err.(interface{ SQLState() string }); ok {
+		state := se.SQLState()
+		for _, code := range c.sqlstate {
+			if state == code || len(code) == 2 && len(state) == 5 && state[:2] == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	if code, ok := sqliteCode(err); ok {
+		for _, n := range c.sqlite {
+			if n == code&0xff {
+				return true
+			}
+		}
+		for _, n := range c.sqliteExtended {
+			if n == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walk calls fn with err and every error it wraps, until fn returns true
+func walk(err error, fn func(err error) bool) bool {
+	for err != nil {
+		if fn(err) {
+			return true
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				if walk(err, fn) {
+					return true
+				}
+			}
+			return false
+		case interface{ GetErrors() []error }:
+			// gorm v1 accumulates errors without wrapping them
+			for _, err := range e.GetErrors() {
+				if walk(err, fn) {
+					return true
+				}
+			}
+			return false
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
+
+// sqliteCode returns the extended result code of a SQLite error. The drivers
+// are not imported, mattn/go-sqlite3 requires cgo.
+func sqliteCode(err error) (int, bool) {
+	// modernc.org/sqlite
+	if e, ok := err.(interface{ Code() int }); ok {
+		return e.Code(), true
+	}
+
+	// mattn/go-sqlite3, its Error is returned by value
+	v := reflect.ValueOf(err)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return 0, false
+	}
+	t := v.Type()
+	if t.PkgPath() != "github.com/mattn/go-sqlite3" || t.Name() != "Error" {
+		return 0, false
+	}
+	if code := v.FieldByName("ExtendedCode"); code.IsValid() && code.Int() != 0 {
+		return int(code.Int()), true
+	}
+	if code := v.FieldByName("Code"); code.IsValid() {
+		return int(code.Int()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,132 @@
+package dberr_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/mattn/go-sqlite3"
+	"github.com/shortlyst-ai/go-txmanager/v2/dberr"
+	"github.com/stretchr/testify/require"
+	sqlitev2 "gorm.io/driver/sqlite"
+	gormv2 "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// pgError stands for pgconn.PgError and pq.Error
+type pgError struct {
+	code string
+}
+
+func (e *pgError) Error() string    { return "pg: " + e.code }
+func (e *pgError) SQLState() string { return e.code }
+
+type classifier struct {
+	name string
+	is   func(error) bool
+}
+
+var classifiers = []classifier{
+	{"Deadlock", dberr.IsDeadlock},
+	{"LockTimeout", dberr.IsLockTimeout},
+	{"SerializationFailure", dberr.IsSerializationFailure},
+	{"UniqueViolation", dberr.IsUniqueViolation},
+	{"ForeignKeyViolation", dberr.IsForeignKeyViolation},
+	{"ConnectionLost", dberr.IsConnectionLost},
+	{"ReadOnlyViolation", dberr.IsReadOnlyViolation},
+}
+
+// requireClass fails unless err is classified as want only
+func requireClass(t *testing.T, want string, err error) {
+	t.Helper()
+	for _, c := range classifiers {
+		require.Equal(t, c.name == want, c.is(err), "%s(%v)", c.name, err)
+	}
+}
+
+func TestClassification(t *testing.T) {
+	tests := []struct {
+		class string
+		err   error
+	}{
+		{"Deadlock", &mysql.MySQLError{Number: 1213}},
+		{"Deadlock", &pgError{"40P01"}},
+		{"LockTimeout", &mysql.MySQLError{Number: 1205}},
+		{"LockTimeout", &pgError{"55P03"}},
+		{"LockTimeout", sqlite3.Error{Code: sqlite3.ErrBusy}},
+		{"SerializationFailure", &pgError{"40001"}},
+		{"SerializationFailure", sqlite3.Error{Code: sqlite3.ErrBusy, ExtendedCode: sqlite3.ErrBusySnapshot}},
+		{"UniqueViolation", &mysql.MySQLError{Number: 1062}},
+		{"UniqueViolation", &pgError{"23505"}},
+		{"UniqueViolation", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}},
+		{"ForeignKeyViolation", &mysql.MySQLError{Number: 1452}},
+		{"ForeignKeyViolation", &pgError{"23503"}},
+		{"ConnectionLost", mysql.ErrInvalidConn},
+		{"ConnectionLost", driver.ErrBadConn},
+		{"ConnectionLost", &pgError{"08006"}},
+		{"ReadOnlyViolation", &mysql.MySQLError{Number: 1290}},
+		{"ReadOnlyViolation", &pgError{"25006"}},
+		{"ReadOnlyViolation", sqlite3.Error{Code: sqlite3.ErrReadonly}},
+		{"", &mysql.MySQLError{Number: 1064}},
+		{"", &pgError{"42601"}},
+		{"", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}},
+		{"", errors.New("Deadlock found when trying to get lock")},
+		{"", nil},
+	}
+	for _, test := range tests {
+		requireClass(t, test.class, test.err)
+	}
+
+	t.Run("Wrapped", func(t *testing.T) {
+		deadlock := &mysql.MySQLError{Number: 1213}
+		requireClass(t, "Deadlock", fmt.Errorf("update order: %w", deadlock))
+		requireClass(t, "Deadlock", gorm.Errors{errors.New("first"), deadlock})
+		requireClass(t, "Deadlock", fmt.Errorf("wrapped twice: %w", gorm.Errors{deadlock}))
+	})
+}
+
+type parent struct {
+	ID uint
+}
+
+type child struct {
+	ID       uint
+	Code     string `gorm:"unique_index;uniqueIndex"`
+	ParentID uint   `sql:"type:integer REFERENCES parents(id)"`
+	Parent   *parent
+}
+
+func sqliteDSN(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "dberr.db") + "?_foreign_keys=1"
+}
+
+func TestSQLite(t *testing.T) {
+	t.Run("GormV1", func(t *testing.T) {
+		db, err := gorm.Open("sqlite3", sqliteDSN(t))
+		require.NoError(t, err)
+		defer db.Close()
+		db.LogMode(false)
+		require.NoError(t, db.AutoMigrate(&parent{}, &child{}).Error)
+		require.NoError(t, db.Create(&parent{ID: 1}).Error)
+		require.NoError(t, db.Create(&child{Code: "a", ParentID: 1}).Error)
+
+		requireClass(t, "UniqueViolation", db.Create(&child{Code: "a", ParentID: 1}).Error)
+		requireClass(t, "ForeignKeyViolation", db.Create(&child{Code: "b", ParentID: 2}).Error)
+	})
+
+	t.Run("GormV2", func(t *testing.T) {
+		db, err := gormv2.Open(sqlitev2.Open(sqliteDSN(t)), &gormv2.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&parent{}, &child{}))
+		require.NoError(t, db.Create(&parent{ID: 1}).Error)
+		require.NoError(t, db.Create(&child{Code: "a", ParentID: 1}).Error)
+
+		requireClass(t, "UniqueViolation", db.Create(&child{Code: "a", ParentID: 1}).Error)
+		requireClass(t, "ForeignKeyViolation", db.Create(&child{Code: "b", ParentID: 2}).Error)
+	})
+}
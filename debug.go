@@ -0,0 +1,56 @@
+package txmanager
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"time"
+)
+
+var activeTxsTemplate = template.Must(template.New("txmanager").Parse(`<html>
+<head><title>txmanager: active transactions</title></head>
+<body>
+<p>{{len .Transactions}} active transactions on {{.Host}} at {{.Now.Format "2006-01-02T15:04:05Z07:00"}}</p>
+<table border="1" cellpadding="4">
+<tr><th>Name</th><th>Manager</th><th>Goroutine</th><th>Started</th><th>Elapsed</th><th>Depth</th><th>Last statement</th><th>Stack</th></tr>
+{{range .Transactions}}<tr>
+<td>{{.Info.Name}}</td><td>{{.Info.Manager}}</td><td>{{.Goroutine}}</td>
+<td>{{.Info.StartTime.Format "2006-01-02T15:04:05.000Z07:00"}}</td><td>{{.Info.Duration}}</td><td>{{.Info.Depth}}</td>
+<td><pre>{{.LastStatement}}</pre></td><td><pre>{{.Stack}}</pre></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// activeTxsPage is rendered by Handler
+type activeTxsPage struct {
+	Host         string
+	Now          time.Time
+	Transactions []ActiveTx
+}
+
+// Handler serves the transactions open in this process, in the style of
+// net/http/pprof. It renders HTML, or JSON with ?format=json. Mount it, for
+// example, at /debug/txmanager.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := activeTxsPage{Now: time.Now(), Transactions: ActiveTransactions()}
+		page.Host, _ = os.Hostname()
+		if page.Transactions == nil {
+			page.Transactions = []ActiveTx{}
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if r.FormValue("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(page)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = activeTxsTemplate.Execute(w, page)
+	})
+}
@@ -0,0 +1,211 @@
+package txmanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2/dberr"
+	"github.com/sirupsen/logrus"
+)
+
+// diagnoseTimeout bounds the diagnostics queries, they must not hold up the caller
+const diagnoseTimeout = 5 * time.Second
+
+// LockError is returned by WithTransaction, with DiagnoseLocks, when the
+// transaction failed on a deadlock or a lock timeout. It unwraps to the error
+// of the database.
+type LockError struct {
+	Err error
+	// Diagnostics is the lock report of the database taken right after the
+	// failure. On MySQL it is the latest deadlock section of SHOW ENGINE INNODB
+	// STATUS, or its transactions section on a lock timeout. On Postgres it is
+	// the blocked queries with the ones blocking them, from pg_stat_activity and
+	// pg_blocking_pids. Empty if not supported.
+	Diagnostics string
+	// Statements are the statements run in the transaction, the last 100 of a
+	// longer transaction
+	Statements []Entry
+	// DroppedStatements is the number of statements run before Statements
+	DroppedStatements int
+}
+
+func (e *LockError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// DiagnoseLocks makes a transaction failing on a deadlock or a lock timeout
+// query the lock report of the database on a separate connection. The report
+// and the statements of the transaction are logged and returned in a LockError.
+func DiagnoseLocks() Option {
+	return func(c *config) {
+		c.diagnoseLocks = true
+	}
+}
+
+// diagnosticDriver is implemented by the built-in drivers
+type diagnosticDriver interface {
+	// diagnoseLocks returns the lock report of the database, deadlock telling
+	// whether the latest deadlock or the current lock waits are wanted
+	diagnoseLocks(ctx context.Context, deadlock bool) (string, error)
+}
+
+// diagnose wraps err into a LockError when it is a lock failure of a top-level
+// transaction, it is called once the transaction is rolled back
+func (s *txState) diagnose(err error) error {
+	if !s.config.diagnoseLocks || s.parent != nil {
+		return err
+	}
+	deadlock := dberr.IsDeadlock(err)
+	if !deadlock && !dberr.IsLockTimeout(err) {
+		return err
+	}
+
+	lockErr := &LockError{Err: err, Statements: s.statements.Entries(), DroppedStatements: s.statements.Dropped()}
+	if d, ok := s.driver.(diagnosticDriver); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+		defer cancel()
+		diagnostics, diagErr := d.diagnoseLocks(ctx, deadlock)
+		if diagErr != nil {
+			logrus.Warnf("txmanager: cannot diagnose the lock failure of transaction %q: %v", s.info.Name, diagErr)
+		}
+		lockErr.Diagnostics = diagnostics
+	}
+
+	statements := make([]string, len(lockErr.Statements))
+	for i, e := range lockErr.Statements {
+		statements[i] = e.String()
+	}
+	logrus.WithFields(logrus.Fields{
+		"tx_name":            s.info.Name,
+		"diagnostics":        lockErr.Diagnostics,
+		"statements":         statements,
+		"dropped_statements": lockErr.DroppedStatements,
+	}).WithError(err).Error("txmanager: transaction failed on a lock")
+	return lockErr
+}
+
+// pgBlockedQuery lists the queries waiting for a lock with the ones holding it
+const pgBlockedQuery = `SELECT blocked.pid, blocked.query, blocking.pid, blocking.query
+FROM pg_stat_activity blocked
+JOIN pg_stat_activity blocking ON blocking.pid = ANY(pg_blocking_pids(blocked.pid))`
+
+// lockQuery returns the query reporting the locks on the given dialect
+func lockQuery(dialect string) string {
+	switch dialect {
+	case "mysql":
+		return "SHOW ENGINE INNODB STATUS"
+	case "postgres":
+		return pgBlockedQuery
+	}
+	return ""
+}
+
+// lockReport formats the rows returned by lockQuery
+func lockReport(dialect string, deadlock bool, rows [][]string) string {
+	if dialect == "mysql" {
+		if len(rows) == 0 || len(rows[0]) < 3 {
+			return ""
+		}
+		if deadlock {
+			return innodbSection(rows[0][2], "LATEST DETECTED DEADLOCK")
+		}
+		return innodbSection(rows[0][2], "TRANSACTIONS")
+	}
+
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "pid %s waiting on: %s\n\tblocked by pid %s: %s\n", row[0], row[1], row[2], row[3])
+	}
+	return b.String()
+}
+
+// innodbSection returns the body of a section of SHOW ENGINE INNODB STATUS,
+// sections being titles framed by dashed lines
+func innodbSection(status, title string) string {
+	lines := strings.Split(status, "\n")
+	isDashes := func(line string) bool {
+		return len(line) > 0 && strings.Trim(line, "-") == ""
+	}
+
+	for i := 1; i+1 < len(lines); i++ {
+		if lines[i] != title || !isDashes(lines[i-1]) || !isDashes(lines[i+1]) {
+			continue
+		}
+		body := lines[i+2:]
+		for j := 0; j+2 < len(body); j++ {
+			if isDashes(body[j]) && !isDashes(body[j+1]) && isDashes(body[j+2]) {
+				body = body[:j]
+				break
+			}
+		}
+		return strings.TrimSpace(strings.Join(body, "\n"))
+	}
+	return ""
+}
+
+func (d gormDriver) diagnoseLocks(ctx context.Context, deadlock bool) (string, error) {
+	dialect := d.db.Dialect().GetName()
+	query := lockQuery(dialect)
+	if query == "" {
+		return "", nil
+	}
+	rows, err := d.db.DB().QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	result, err := scanStrings(rows)
+	if err != nil {
+		return "", err
+	}
+	return lockReport(dialect, deadlock, result), nil
+}
+
+func (d gormV2Driver) diagnoseLocks(ctx context.Context, deadlock bool) (string, error) {
+	dialect := d.db.Dialector.Name()
+	query := lockQuery(dialect)
+	if query == "" {
+		return "", nil
+	}
+	rows, err := d.db.WithContext(ctx).Raw(query).Rows()
+	if err != nil {
+		return "", err
+	}
+	result, err := scanStrings(rows)
+	if err != nil {
+		return "", err
+	}
+	return lockReport(dialect, deadlock, result), nil
+}
+
+// scanStrings reads every row as strings, NULL being empty
+func scanStrings(rows *sql.Rows) ([][]string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new([]byte)
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = string(*v.(*[]byte))
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
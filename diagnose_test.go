@@ -0,0 +1,74 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/dberr"
+	"github.com/stretchr/testify/require"
+	sqlitev2 "gorm.io/driver/sqlite"
+	gormv2 "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDiagnoseLocks(t *testing.T) {
+	// deferred transactions with a short busy timeout, so that a write waiting
+	// for another transaction fails fast with SQLITE_BUSY
+	dsn := filepath.Join(t.TempDir(), "txmanager.db") + "?_journal_mode=WAL&_busy_timeout=50"
+	db, err := gormv2.Open(sqlitev2.Open(dsn), &gormv2.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&record{}))
+	txManager := txmanager.NewGormTxManager(db, txmanager.CaptureStatements())
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	holderErr := make(chan error, 1)
+	go func() {
+		holderErr <- txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error; err != nil {
+				return err
+			}
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+
+	err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		var count int64
+		if err := txmanager.GetTxConnV2(ctx).Model(&record{}).Count(&count).Error; err != nil {
+			return err
+		}
+		return txmanager.GetTxConnV2(ctx).Create(&record{Key: "b"}).Error
+	}, txmanager.DiagnoseLocks(), txmanager.Name("blocked"))
+	close(release)
+	require.NoError(t, <-holderErr)
+
+	var lockErr *txmanager.LockError
+	require.True(t, errors.As(err, &lockErr), "expected *txmanager.LockError, got %v", err)
+	require.True(t, dberr.IsLockTimeout(err))
+	require.Equal(t, lockErr.Err.Error(), err.Error())
+
+	var statements []string
+	for _, e := range lockErr.Statements {
+		statements = append(statements, e.String())
+	}
+	require.Equal(t, []string{
+		"BEGIN",
+		"SELECT count(*) FROM `records`",
+		"INSERT INTO `records` (`key`) VALUES (?) [b]",
+		"ROLLBACK",
+	}, statements)
+
+	t.Run("OtherErrorsUntouched", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return errFailed
+		}, txmanager.DiagnoseLocks())
+		require.Equal(t, errFailed, err)
+	})
+}
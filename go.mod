@@ -1,12 +1,15 @@
-module github.com/shortlyst-ai/go-txmanager
+module github.com/shortlyst-ai/go-txmanager/v2
 
 go 1.16
 
 require (
+	github.com/go-sql-driver/mysql v1.7.0
 	github.com/jinzhu/gorm v1.9.16
+	github.com/mattn/go-sqlite3 v1.14.15
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/sys v0.3.0 // indirect
 	gorm.io/driver/mysql v1.4.5
+	gorm.io/driver/sqlite v1.4.4
 	gorm.io/gorm v1.24.3
 )
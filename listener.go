@@ -0,0 +1,96 @@
+package txmanager
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TxInfo describes a transaction at the moment a TxListener is notified, or
+// when it is looked up with Info
+type TxInfo struct {
+	// Manager is the name given to the TxManager with ManagerName, empty if none
+	Manager string
+	// Name is the name given with the Name option, empty if none
+	Name string
+	// Depth is the savepoint depth of the transaction, 0 for a top-level one
+	Depth int
+	// Isolation is the isolation level requested with the Isolation option
+	Isolation sql.IsolationLevel
+	// ReadOnly reports whether the transaction was begun with the ReadOnly option
+	ReadOnly bool
+	// StartTime is the time the transaction began
+	StartTime time.Time
+	// Duration is the time elapsed since StartTime
+	Duration time.Duration
+	// Attempt is the 1-based attempt number of the transaction
+	Attempt int
+	// CommitHooks is the number of hooks registered with AfterCommit
+	CommitHooks int
+	// Err is the error that ended the transaction, if any
+	Err error
+}
+
+// TxListener is notified about the lifecycle of transactions run by a TxManager.
+// OnPanic and OnCancel are followed by OnRollback once the transaction has been
+// rolled back. OnCommit is called after the commit was attempted, with Err set
+// when it failed. OnRetry is called before OnBegin when the transaction is
+// attempted again by the Retry middleware, with Err set to the previous error.
+type TxListener interface {
+	OnBegin(ctx context.Context, info TxInfo)
+	OnCommit(ctx context.Context, info TxInfo)
+	OnRollback(ctx context.Context, info TxInfo)
+	OnPanic(ctx context.Context, info TxInfo)
+	OnRetry(ctx context.Context, info TxInfo)
+	OnCancel(ctx context.Context, info TxInfo)
+}
+
+// NopTxListener implements TxListener with no-op callbacks, embed it to only
+// implement the callbacks you need
+type NopTxListener struct{}
+
+func (NopTxListener) OnBegin(context.Context, TxInfo)    {}
+func (NopTxListener) OnCommit(context.Context, TxInfo)   {}
+func (NopTxListener) OnRollback(context.Context, TxInfo) {}
+func (NopTxListener) OnPanic(context.Context, TxInfo)    {}
+func (NopTxListener) OnRetry(context.Context, TxInfo)    {}
+func (NopTxListener) OnCancel(context.Context, TxInfo)   {}
+
+// listeners fans out every callback to all registered listeners
+type listeners []TxListener
+
+func (ls listeners) OnBegin(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnBegin(ctx, info)
+	}
+}
+
+func (ls listeners) OnCommit(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnCommit(ctx, info)
+	}
+}
+
+func (ls listeners) OnRollback(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnRollback(ctx, info)
+	}
+}
+
+func (ls listeners) OnPanic(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnPanic(ctx, info)
+	}
+}
+
+func (ls listeners) OnRetry(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnRetry(ctx, info)
+	}
+}
+
+func (ls listeners) OnCancel(ctx context.Context, info TxInfo) {
+	for _, l := range ls {
+		l.OnCancel(ctx, info)
+	}
+}
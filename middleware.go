@@ -0,0 +1,201 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Invoker starts a transaction and runs txfn inside it, it has the same
+// signature as TxManager.WithTransaction
+type Invoker func(ctx context.Context, txfn TxFn, opts ...Option) error
+
+// Middleware decorates an Invoker. A middleware can act before the transaction
+// begins and after it ends, or wrap txfn to run code inside the transaction with
+// access to the tx ctx
+type Middleware func(next Invoker) Invoker
+
+type chain struct {
+	base   TxManager
+	invoke Invoker
+}
+
+// Chain returns a TxManager running every transaction of base through mws,
+// the first middleware being the outermost one
+func Chain(base TxManager, mws ...Middleware) TxManager {
+	invoke := base.WithTransaction
+	for i := len(mws) - 1; i >= 0; i-- {
+		invoke = mws[i](invoke)
+	}
+	return &chain{base: base, invoke: invoke}
+}
+
+func (c *chain) WithTransaction(ctx context.Context, txfn TxFn, opts ...Option) error {
+	return c.invoke(ctx, txfn, opts...)
+}
+
+// WithSession runs fn in a session of the base TxManager when it is a
+// SessionManager, fn runs without middlewares
+func (c *chain) WithSession(ctx context.Context, fn TxFn) error {
+	if s, ok := c.base.(SessionManager); ok {
+		return s.WithSession(ctx, fn)
+	}
+	return errors.New("txmanager: sessions are not supported by the base TxManager")
+}
+
+// Shutdown shuts the base TxManager down when it is a Shutdowner
+func (c *chain) Shutdown(ctx context.Context) error {
+	if s, ok := c.base.(Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// AfterBegin runs fn inside the transaction right after it began, before txfn.
+// An error returned by fn rolls the transaction back.
+func AfterBegin(fn TxFn) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) error {
+			return next(ctx, func(txCtx context.Context) error {
+				if err := fn(txCtx); err != nil {
+					return err
+				}
+				return txfn(txCtx)
+			}, opts...)
+		}
+	}
+}
+
+// Timeout cancels the transaction when it runs longer than d
+func Timeout(d time.Duration) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, txfn, opts...)
+		}
+	}
+}
+
+// RetryPolicy decides whether and when a failed transaction is attempted again
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int
+	// Backoff returns the delay before the given attempt, no delay if nil
+	Backoff func(attempt int) time.Duration
+	// Retryable reports whether err is worth another attempt. If nil every
+	// error is retried except panics and context cancellation
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var pe *PanicError
+	if errors.As(err, &pe) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable == nil {
+		return true
+	}
+	return p.Retryable(err)
+}
+
+// retryState is stored in the ctx by Retry so that managers can report the
+// attempt to their listeners, and tell Retry when an error was committed
+type retryState struct {
+	attempt   int
+	lastErr   error
+	committed bool
+}
+
+type retryKey struct{}
+
+func getRetryState(ctx context.Context) *retryState {
+	if state, ok := ctx.Value(retryKey{}).(*retryState); ok && state != nil {
+		return state
+	}
+	return &retryState{attempt: 1}
+}
+
+// Retry runs the transaction again, in a new transaction, as long as it fails
+// with a retryable error and policy.MaxAttempts is not reached. Errors that were
+// committed because of NoRollbackOn or NoRollbackFor are never retried.
+func Retry(policy RetryPolicy) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) (err error) {
+			for attempt := 1; ; attempt++ {
+				state := &retryState{attempt: attempt, lastErr: err}
+				err = next(context.WithValue(ctx, retryKey{}, state), txfn, opts...)
+				if err == nil || state.committed || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+					return err
+				}
+
+				if policy.Backoff == nil {
+					continue
+				}
+				timer := time.NewTimer(policy.Backoff(attempt + 1))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
+
+// Logging logs the outcome and duration of every transaction to logger
+func Logging(logger logrus.FieldLogger) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) error {
+			start := time.Now()
+			err := next(ctx, txfn, opts...)
+
+			entry := logger.WithFields(logrus.Fields{
+				"tx_name":  newConfig(opts).name,
+				"duration": time.Since(start),
+			})
+			if err != nil {
+				entry.WithError(err).Error("transaction failed")
+			} else {
+				entry.Debug("transaction committed")
+			}
+			return err
+		}
+	}
+}
+
+// Tracer starts a span for the named transaction, the returned func ends the
+// span with the transaction error
+type Tracer func(ctx context.Context, name string) (context.Context, func(err error))
+
+// Tracing wraps every transaction in a span started by tracer
+func Tracing(tracer Tracer) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) error {
+			ctx, end := tracer(ctx, newConfig(opts).name)
+			err := next(ctx, txfn, opts...)
+			end(err)
+			return err
+		}
+	}
+}
+
+// Limit allows at most n transactions to run concurrently, callers wait in
+// FIFO order until a slot is free or their ctx is done
+func Limit(n int) Middleware {
+	slots := make(chan struct{}, n)
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, txfn TxFn, opts ...Option) error {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-slots }()
+			return next(ctx, txfn, opts...)
+		}
+	}
+}
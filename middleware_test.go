@@ -0,0 +1,122 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// inlineTxManager runs the TxFn directly, without a database
+type inlineTxManager struct {
+	calls int32
+}
+
+func (m *inlineTxManager) WithTransaction(ctx context.Context, txfn txmanager.TxFn, opts ...txmanager.Option) error {
+	atomic.AddInt32(&m.calls, 1)
+	return txfn(ctx)
+}
+
+func TestChain(t *testing.T) {
+	t.Run("MiddlewareOrder", func(t *testing.T) {
+		var order []string
+		record := func(name string) txmanager.Middleware {
+			return func(next txmanager.Invoker) txmanager.Invoker {
+				return func(ctx context.Context, txfn txmanager.TxFn, opts ...txmanager.Option) error {
+					order = append(order, name)
+					return next(ctx, txfn, opts...)
+				}
+			}
+		}
+
+		txManager := txmanager.Chain(&inlineTxManager{}, record("first"), record("second"))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			order = append(order, "txfn")
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"first", "second", "txfn"}, order)
+	})
+
+	t.Run("AfterBegin", func(t *testing.T) {
+		errSetup := errors.New("setup failed")
+		txManager := txmanager.Chain(&inlineTxManager{}, txmanager.AfterBegin(func(ctx context.Context) error {
+			return errSetup
+		}))
+
+		called := false
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			called = true
+			return nil
+		})
+		require.True(t, errors.Is(err, errSetup))
+		require.False(t, called)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		txManager := txmanager.Chain(&inlineTxManager{}, txmanager.Timeout(time.Minute))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			require.True(t, ok)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		errDeadlock := errors.New("deadlock")
+		base := &inlineTxManager{}
+		txManager := txmanager.Chain(base, txmanager.Retry(txmanager.RetryPolicy{
+			MaxAttempts: 3,
+			Retryable: func(err error) bool {
+				return errors.Is(err, errDeadlock)
+			},
+		}))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return errDeadlock
+		})
+		require.True(t, errors.Is(err, errDeadlock))
+		require.Equal(t, int32(3), base.calls)
+
+		base.calls = 0
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return errors.New("not retryable")
+		})
+		require.Error(t, err)
+		require.Equal(t, int32(1), base.calls)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		txManager := txmanager.Chain(&inlineTxManager{}, txmanager.Limit(2))
+
+		var running, maxRunning int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+					n := atomic.AddInt32(&running, 1)
+					defer atomic.AddInt32(&running, -1)
+					for {
+						max := atomic.LoadInt32(&maxRunning)
+						if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+					return nil
+				})
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		require.True(t, maxRunning <= 2)
+	})
+}
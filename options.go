@@ -0,0 +1,115 @@
+package txmanager
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Option configures a TxManager when passed to its constructor, or a single
+// transaction when passed to WithTransaction. Per-call options are applied on
+// top of the manager options.
+type Option func(*config)
+
+type config struct {
+	manager       string
+	name          string
+	isolation     sql.IsolationLevel
+	readOnly      bool
+	listeners     []TxListener
+	rollbackOn    []ErrorMatcher
+	noRollbackFor []ErrorMatcher
+	recorders     []*Recorder
+	maxDuration   time.Duration
+	slowThreshold time.Duration
+	slowHandler   SlowTxHandler
+	trackCallers  bool
+	maxConcurrent int
+	maxWait       time.Duration
+	nameQuotas    map[string]int
+	breaker       *breaker
+	diagnoseLocks bool
+	capture       bool
+	vars          []VarsFunc
+	nest          bool
+	// nested makes the transaction a savepoint of the one in ctx, whatever its driver
+	nested bool
+}
+
+// with returns a copy of c with opts applied, leaving c untouched
+func (c config) with(opts []Option) config {
+	c.listeners = append([]TxListener(nil), c.listeners...)
+	c.rollbackOn = append([]ErrorMatcher(nil), c.rollbackOn...)
+	c.noRollbackFor = append([]ErrorMatcher(nil), c.noRollbackFor...)
+	c.recorders = append([]*Recorder(nil), c.recorders...)
+	c.vars = append([]VarsFunc(nil), c.vars...)
+	if c.nameQuotas != nil {
+		nameQuotas := make(map[string]int, len(c.nameQuotas))
+		for name, n := range c.nameQuotas {
+			nameQuotas[name] = n
+		}
+		c.nameQuotas = nameQuotas
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+	return c
+}
+
+func newConfig(opts []Option) config {
+	return config{}.with(opts)
+}
+
+// ManagerName names the TxManager in the TxInfo of its transactions
+func ManagerName(name string) Option {
+	return func(c *config) {
+		c.manager = name
+	}
+}
+
+// Name sets the transaction name reported to listeners
+func Name(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// Isolation begins the transaction with the given isolation level, the driver
+// default is used if not set
+func Isolation(level sql.IsolationLevel) Option {
+	return func(c *config) {
+		c.isolation = level
+	}
+}
+
+// ReadOnly begins a read-only transaction, on a replica when run by a
+// ReplicatedTxManager. MySQL and Postgres reject its writes, and on the managers
+// capturing statements writes run through gorm fail with ErrReadOnlyTransaction.
+func ReadOnly() Option {
+	return func(c *config) {
+		c.readOnly = true
+	}
+}
+
+func (c config) txOptions() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: c.isolation, ReadOnly: c.readOnly}
+}
+
+// Nested runs a transaction begun with a ctx already in a transaction of the
+// same database as a savepoint of it: when it fails only its work is rolled
+// back, and its work is lost when the outer transaction rolls back. Without it
+// the transaction is independent and commits on its own. It can be given to the
+// manager or to WithTransaction.
+func Nested() Option {
+	return func(c *config) {
+		c.nest = true
+	}
+}
+
+// WithListeners registers listeners notified about the transaction lifecycle
+func WithListeners(listeners ...TxListener) Option {
+	return func(c *config) {
+		c.listeners = append(c.listeners, listeners...)
+	}
+}
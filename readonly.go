@@ -0,0 +1,236 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	gormv2 "gorm.io/gorm"
+)
+
+// ErrReadOnlyTransaction is returned by a write attempted in a read-only
+// transaction
+var ErrReadOnlyTransaction = errors.New("txmanager: write in a read-only transaction")
+
+const readOnlyCallback = "txmanager:read_only"
+
+// writeKeywords are the first keywords of the raw statements rejected in a
+// read-only transaction
+var writeKeywords = []string{"INSERT", "UPDATE", "DELETE", "REPLACE", "MERGE", "UPSERT", "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME", "GRANT", "REVOKE"}
+
+// isWrite reports whether the raw statement sql writes
+func isWrite(sql string) bool {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return false
+	}
+	first := strings.ToUpper(fields[0])
+	for _, keyword := range writeKeywords {
+		if first == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnly reports whether the statements of the transaction must not write
+func (s *txState) readOnly() bool {
+	return s.root().info.ReadOnly
+}
+
+func gormReadOnly(scope *gorm.Scope) {
+	if state := connState(scope.SQLDB()); state != nil && state.readOnly() {
+		scope.Err(fmt.Errorf("%w %q", ErrReadOnlyTransaction, state.info.Name))
+	}
+}
+
+func gormV2ReadOnly(db *gormv2.DB) {
+	state := connState(db.Statement.ConnPool)
+	if state == nil || !state.readOnly() {
+		return
+	}
+	if db.Statement.SQL.Len() > 0 && !isWrite(db.Statement.SQL.String()) {
+		// a raw query
+		return
+	}
+	db.AddError(fmt.Errorf("%w %q", ErrReadOnlyTransaction, state.info.Name))
+}
+
+// Balancer picks the replica a read-only transaction begins on
+type Balancer interface {
+	// Pick returns one of the healthy replicas, given by their index in the
+	// replicas of the ReplicatedTxManager, never empty
+	Pick(ctx context.Context, healthy []int) int
+}
+
+// BalancerFunc is a Balancer calling a func
+type BalancerFunc func(ctx context.Context, healthy []int) int
+
+func (f BalancerFunc) Pick(ctx context.Context, healthy []int) int { return f(ctx, healthy) }
+
+// RoundRobin returns a Balancer picking the healthy replicas in turn
+func RoundRobin() Balancer {
+	var next uint64
+	return BalancerFunc(func(_ context.Context, healthy []int) int {
+		return healthy[(atomic.AddUint64(&next, 1)-1)%uint64(len(healthy))]
+	})
+}
+
+// RandomBalancer returns a Balancer picking a healthy replica at random
+func RandomBalancer() Balancer {
+	return BalancerFunc(func(_ context.Context, healthy []int) int {
+		return healthy[rand.Intn(len(healthy))]
+	})
+}
+
+// ReplicatedTxManager runs read-only transactions, begun with the ReadOnly
+// option, on a replica and every other transaction on the primary. A replica
+// failing to begin a transaction is skipped for Cooldown and the transaction
+// goes to another one, or to the primary when no replica is healthy.
+//
+// A read-only transaction begun in a transaction of the manager is nested in it,
+// on the primary or on the replica, a read-write transaction begun in a
+// read-only one fails with ErrReadOnlyTransaction.
+type ReplicatedTxManager struct {
+	// Cooldown is how long a replica failing to begin is skipped, 5s by default
+	Cooldown time.Duration
+
+	primary  TxManager
+	replicas []TxManager
+	balancer Balancer
+
+	mu        sync.Mutex
+	downUntil []time.Time
+}
+
+// replicaTx is stored in the ctx of a transaction begun by a
+// ReplicatedTxManager
+type replicaTx struct {
+	manager *ReplicatedTxManager
+	// replica is the replica the transaction was begun on, -1 for the primary
+	replica int
+	state   *txState
+}
+
+type replicaKey struct{}
+
+// current returns the transaction of r ctx is in, false when ctx is in no
+// transaction or in the transaction of another manager
+func (r *ReplicatedTxManager) current(ctx context.Context) (replicaTx, bool) {
+	rt, ok := ctx.Value(replicaKey{}).(replicaTx)
+	if !ok || rt.manager != r || rt.state == nil {
+		return replicaTx{}, false
+	}
+	state := getTxState(ctx)
+	return rt, state != nil && state.root() == rt.state.root()
+}
+
+// mark returns txfn run with its ctx marked as in the transaction begun by r on
+// replica
+func (r *ReplicatedTxManager) mark(replica int, txfn TxFn) TxFn {
+	return func(ctx context.Context) error {
+		return txfn(context.WithValue(ctx, replicaKey{}, replicaTx{manager: r, replica: replica, state: getTxState(ctx)}))
+	}
+}
+
+// NewReplicatedTxManager create ReplicatedTxManager over primary and replicas,
+// RoundRobin is used when balancer is nil
+func NewReplicatedTxManager(primary TxManager, replicas []TxManager, balancer Balancer) *ReplicatedTxManager {
+	if balancer == nil {
+		balancer = RoundRobin()
+	}
+	return &ReplicatedTxManager{
+		Cooldown:  5 * time.Second,
+		primary:   primary,
+		replicas:  append([]TxManager(nil), replicas...),
+		balancer:  balancer,
+		downUntil: make([]time.Time, len(replicas)),
+	}
+}
+
+func (r *ReplicatedTxManager) WithTransaction(ctx context.Context, txfn TxFn, opts ...Option) error {
+	readOnly := newConfig(opts).readOnly
+	if rt, ok := r.current(ctx); ok {
+		manager := r.primary
+		if rt.replica >= 0 {
+			if !readOnly {
+				info, _ := Info(ctx)
+				return fmt.Errorf("%w %q, begun on a replica", ErrReadOnlyTransaction, info.Name)
+			}
+			manager = r.replicas[rt.replica]
+		}
+		if readOnly {
+			// read in the transaction, seeing its writes
+			opts = append(opts[:len(opts):len(opts)], Nested())
+		}
+		return manager.WithTransaction(ctx, txfn, opts...)
+	}
+	if !readOnly {
+		return r.primary.WithTransaction(ctx, r.mark(-1, txfn), opts...)
+	}
+
+	tried := make([]bool, len(r.replicas))
+	for {
+		i, ok := r.pick(ctx, tried)
+		if !ok {
+			return r.primary.WithTransaction(ctx, r.mark(-1, txfn), opts...)
+		}
+		tried[i] = true
+
+		began := false
+		err := r.replicas[i].WithTransaction(ctx, r.mark(i, func(ctx context.Context) error {
+			began = true
+			return txfn(ctx)
+		}), opts...)
+		if err == nil || began || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, ErrTooManyTransactions) {
+			r.down(i)
+		}
+	}
+}
+
+// pick returns a healthy replica not tried yet
+func (r *ReplicatedTxManager) pick(ctx context.Context, tried []bool) (int, bool) {
+	now := time.Now()
+	var healthy []int
+	r.mu.Lock()
+	for i, until := range r.downUntil {
+		if !tried[i] && !now.Before(until) {
+			healthy = append(healthy, i)
+		}
+	}
+	r.mu.Unlock()
+	if len(healthy) == 0 {
+		return 0, false
+	}
+	return r.balancer.Pick(ctx, healthy), true
+}
+
+// down skips replica i for Cooldown
+func (r *ReplicatedTxManager) down(i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil[i] = time.Now().Add(r.Cooldown)
+}
+
+// Shutdown shuts down the primary and the replicas that are Shutdowners,
+// returning the first error
+func (r *ReplicatedTxManager) Shutdown(ctx context.Context) error {
+	var first error
+	for _, m := range append([]TxManager{r.primary}, r.replicas...) {
+		if s, ok := m.(Shutdowner); ok {
+			if err := s.Shutdown(ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
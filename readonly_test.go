@@ -0,0 +1,197 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+	gormv2 "gorm.io/gorm"
+)
+
+func TestReadOnly(t *testing.T) {
+	t.Run("GormV2", func(t *testing.T) {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		txManager := txmanager.NewGormTxManager(db, txmanager.CaptureStatements())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			info, _ := txmanager.Info(ctx)
+			require.True(t, info.ReadOnly)
+
+			var count int64
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Model(&record{}).Count(&count).Error)
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Raw("SELECT COUNT(*) FROM records").Row().Scan(&count))
+
+			err := txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error
+			require.True(t, errors.Is(err, txmanager.ErrReadOnlyTransaction), err)
+			err = txmanager.GetTxConnV2(ctx).Exec("INSERT INTO records (key) VALUES ('a')").Error
+			require.True(t, errors.Is(err, txmanager.ErrReadOnlyTransaction), err)
+
+			// savepoints of a read-only transaction are read-only
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				return txmanager.GetTxConnV2(ctx).Where("key = ?", "a").Delete(&record{}).Error
+			}, txmanager.Nested())
+		}, txmanager.ReadOnly(), txmanager.Name("report"))
+		require.True(t, errors.Is(err, txmanager.ErrReadOnlyTransaction), err)
+	})
+
+	t.Run("Gorm", func(t *testing.T) {
+		db := getSqlite(t)
+		require.NoError(t, db.AutoMigrate(&record{}).Error)
+		txManager := txmanager.StartTxManager(db, txmanager.CaptureStatements())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			var count int
+			require.NoError(t, txmanager.GetTxConn(ctx).Model(&record{}).Count(&count).Error)
+			return txmanager.GetTxConn(ctx).Create(&record{Key: "a"}).Error
+		}, txmanager.ReadOnly())
+		require.True(t, errors.Is(err, txmanager.ErrReadOnlyTransaction), err)
+
+		// read-write transactions are untouched
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConn(ctx).Create(&record{Key: "a"}).Error
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestReplicatedTxManager(t *testing.T) {
+	// every database holds its own name, to tell where a transaction ran
+	open := func(name string) *gormv2.DB {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		require.NoError(t, db.Create(&record{Key: name}).Error)
+		return db
+	}
+	where := func(ctx context.Context) string {
+		var r record
+		require.NoError(t, txmanager.GetTxConnV2(ctx).Order("key").First(&r).Error)
+		return r.Key
+	}
+	newManager := func(replicas ...txmanager.TxManager) *txmanager.ReplicatedTxManager {
+		if replicas == nil {
+			replicas = []txmanager.TxManager{
+				txmanager.NewGormTxManager(open("replica1")),
+				txmanager.NewGormTxManager(open("replica2")),
+			}
+		}
+		return txmanager.NewReplicatedTxManager(txmanager.NewGormTxManager(open("primary")), replicas, nil)
+	}
+
+	t.Run("ReadOnlyOnReplicas", func(t *testing.T) {
+		txManager := newManager()
+		var got []string
+		for i := 0; i < 3; i++ {
+			err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				got = append(got, where(ctx))
+				return nil
+			}, txmanager.ReadOnly())
+			require.NoError(t, err)
+		}
+		require.Equal(t, []string{"replica1", "replica2", "replica1"}, got)
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			got = append(got, where(ctx))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "primary", got[3])
+	})
+
+	t.Run("ReadOnlyNestedInReadWrite", func(t *testing.T) {
+		txManager := newManager()
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: "new"}).Error)
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				require.Equal(t, "new", where(ctx))
+				return nil
+			}, txmanager.ReadOnly())
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("ReadOnlyInOtherManagerTransaction", func(t *testing.T) {
+		txManager := newManager()
+		other := txmanager.NewGormTxManager(open("other"))
+		err := other.WithTransaction(context.Background(), func(ctx context.Context) error {
+			// not in a transaction of the primary, the replicas are used
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				require.Equal(t, "replica1", where(ctx))
+				return nil
+			}, txmanager.ReadOnly())
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("WriteInReplicaTransaction", func(t *testing.T) {
+		txManager := newManager()
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			// nested read-only transactions stay on the replica
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				require.Equal(t, "replica1", where(ctx))
+				return nil
+			}, txmanager.ReadOnly())
+			require.NoError(t, err)
+
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				t.Fatal("expected the read-write transaction not to run")
+				return nil
+			})
+		}, txmanager.ReadOnly())
+		require.True(t, errors.Is(err, txmanager.ErrReadOnlyTransaction), err)
+	})
+
+	t.Run("UnhealthyReplicaSkipped", func(t *testing.T) {
+		down := &flakyDriver{down: true}
+		first := txmanager.BalancerFunc(func(_ context.Context, healthy []int) int {
+			return healthy[0]
+		})
+		replicas := []txmanager.TxManager{txmanager.NewTxManager(down), txmanager.NewGormTxManager(open("replica2"))}
+		txManager := txmanager.NewReplicatedTxManager(txmanager.NewGormTxManager(open("primary")), replicas, first)
+		txManager.Cooldown = 50 * time.Millisecond
+
+		read := func() {
+			err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				return nil
+			}, txmanager.ReadOnly())
+			require.NoError(t, err)
+		}
+		read()
+		read()
+		require.Equal(t, 1, down.begins)
+
+		// the replica is tried again after the cooldown
+		time.Sleep(60 * time.Millisecond)
+		down.setDown(false)
+		read()
+		require.Equal(t, 2, down.begins)
+	})
+
+	t.Run("NoHealthyReplica", func(t *testing.T) {
+		txManager := newManager(txmanager.NewTxManager(&flakyDriver{down: true}))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.Equal(t, "primary", where(ctx))
+			return nil
+		}, txmanager.ReadOnly())
+		require.NoError(t, err)
+	})
+
+	t.Run("Balancer", func(t *testing.T) {
+		replicas := []txmanager.TxManager{
+			txmanager.NewGormTxManager(open("replica1")),
+			txmanager.NewGormTxManager(open("replica2")),
+		}
+		last := txmanager.BalancerFunc(func(_ context.Context, healthy []int) int {
+			return healthy[len(healthy)-1]
+		})
+		txManager := txmanager.NewReplicatedTxManager(txmanager.NewGormTxManager(open("primary")), replicas, last)
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.Equal(t, "replica2", where(ctx))
+			return nil
+		}, txmanager.ReadOnly())
+		require.NoError(t, err)
+	})
+}
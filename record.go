@@ -0,0 +1,110 @@
+package txmanager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// EntryKind is the kind of an Entry recorded by a Recorder
+type EntryKind string
+
+const (
+	EntryBegin      EntryKind = "BEGIN"
+	EntryCommit     EntryKind = "COMMIT"
+	EntryRollback   EntryKind = "ROLLBACK"
+	EntrySavepoint  EntryKind = "SAVEPOINT"
+	EntryRelease    EntryKind = "RELEASE SAVEPOINT"
+	EntryRollbackTo EntryKind = "ROLLBACK TO SAVEPOINT"
+	EntryStatement  EntryKind = "STATEMENT"
+)
+
+// Entry is a statement, or a begin/commit/rollback/savepoint marker, recorded
+// by a Recorder
+type Entry struct {
+	Kind EntryKind
+	// Tx is the name of the transaction the entry belongs to
+	Tx string
+	// SQL is the statement of an EntryStatement, or the savepoint name
+	SQL  string
+	Vars []interface{}
+}
+
+func (e Entry) String() string {
+	switch e.Kind {
+	case EntryStatement:
+		if len(e.Vars) == 0 {
+			return e.SQL
+		}
+		return fmt.Sprintf("%s %v", e.SQL, e.Vars)
+	case EntrySavepoint, EntryRelease, EntryRollbackTo:
+		return string(e.Kind) + " " + e.SQL
+	}
+	return string(e.Kind)
+}
+
+// Recorder records, in order, the statements run through the tx handle of the
+// transactions it is given to with Record, along with begin, commit, rollback
+// and savepoint markers. Statements run with Exec on a gorm v1 handle are not
+// recorded, gorm v1 runs no callback for them. Statements are only recorded by
+// the managers capturing them, see CaptureStatements. The zero value is ready to use.
+type Recorder struct {
+	// Limit keeps only the last Limit entries when positive, the older ones are
+	// counted by Dropped
+	Limit int
+
+	mu      sync.Mutex
+	entries []Entry
+	dropped int
+}
+
+// Record records the transactions into r
+func Record(r *Recorder) Option {
+	return func(c *config) {
+		c.recorders = append(c.recorders, r)
+	}
+}
+
+func (r *Recorder) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Limit > 0 && len(r.entries) >= r.Limit {
+		drop := len(r.entries) - r.Limit + 1
+		n := copy(r.entries, r.entries[drop:])
+		r.entries = r.entries[:n]
+		r.dropped += drop
+	}
+	r.entries = append(r.entries, e)
+}
+
+// Dropped returns the number of entries dropped to keep within Limit
+func (r *Recorder) Dropped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Entries returns the entries recorded so far
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+// Transcript returns the entries recorded so far, one per line
+func (r *Recorder) Transcript() string {
+	var b strings.Builder
+	for _, e := range r.Entries() {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Reset forgets the entries recorded so far, and the dropped ones
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+	r.dropped = 0
+}
@@ -0,0 +1,103 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	t.Run("SQLite", func(t *testing.T) {
+		db := getSqlite(t)
+		require.NoError(t, db.AutoMigrate(&record{}).Error)
+		rec := &txmanager.Recorder{}
+		txManager := txmanager.StartTxManager(db, txmanager.Record(rec), txmanager.Nested())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConn(ctx).Create(&record{Key: "a"}).Error)
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				require.NoError(t, txmanager.GetTxConn(ctx).Create(&record{Key: "b"}).Error)
+				return errFailed
+			}, txmanager.Name("nested"))
+			require.Equal(t, errFailed, err)
+
+			var count int
+			return txmanager.GetTxConn(ctx).Model(&record{}).Count(&count).Error
+		}, txmanager.Name("outer"))
+		require.NoError(t, err)
+
+		// statements outside of the transaction are not recorded
+		require.NoError(t, db.Create(&record{Key: "c"}).Error)
+
+		txmanagertest.AssertGolden(t, filepath.Join("testdata", "recorder_sqlite.golden"), rec.Transcript())
+		entries := rec.Entries()
+		require.Equal(t, "outer", entries[0].Tx)
+		require.Equal(t, "nested", entries[2].Tx)
+	})
+
+	t.Run("SQLiteV2", func(t *testing.T) {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		rec := &txmanager.Recorder{}
+		txManager := txmanager.NewGormTxManager(db, txmanager.CaptureStatements(), txmanager.Nested())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error)
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				return txmanager.GetTxConnV2(ctx).Create(&record{Key: "b"}).Error
+			})
+			require.NoError(t, err)
+			return txmanager.GetTxConnV2(ctx).Exec("DELETE FROM records WHERE key = ?", "a").Error
+		}, txmanager.Record(rec))
+		require.NoError(t, err)
+
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			var count int64
+			return txmanager.GetTxConnV2(ctx).Model(&record{}).Count(&count).Error
+		})
+		require.NoError(t, err)
+
+		txmanagertest.AssertGolden(t, filepath.Join("testdata", "recorder_sqlite_v2.golden"), rec.Transcript())
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		rec := &txmanager.Recorder{Limit: 2}
+		txManager := txmanager.NewGormTxManager(db, txmanager.Record(rec))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error)
+			return txmanager.GetTxConnV2(ctx).Create(&record{Key: "b"}).Error
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, "INSERT INTO `records` (`key`) VALUES (?) [b]\nCOMMIT\n", rec.Transcript())
+		require.Equal(t, 2, rec.Dropped())
+
+		rec.Reset()
+		require.Empty(t, rec.Entries())
+		require.Equal(t, 0, rec.Dropped())
+	})
+}
+
+func TestCaptureStatements(t *testing.T) {
+	// the callbacks are only installed by the managers capturing statements,
+	// never while transactions run
+	db := getSqliteV2(t)
+	txManager := txmanager.NewGormTxManager(db)
+	require.NoError(t, txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, txmanager.Record(&txmanager.Recorder{})))
+	require.Nil(t, db.Callback().Create().Get("txmanager:statement"))
+
+	txmanager.NewGormTxManager(db, txmanager.CaptureStatements())
+	require.NotNil(t, db.Callback().Create().Get("txmanager:statement"))
+}
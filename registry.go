@@ -0,0 +1,88 @@
+package txmanager
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// activeTxs holds the state of every open top-level transaction
+var activeTxs sync.Map
+
+// ActiveTx is a transaction still open, as returned by ActiveTransactions
+type ActiveTx struct {
+	// Info is the TxInfo of the top-level transaction, its Duration is the elapsed time
+	Info TxInfo
+	// Goroutine is the id of the goroutine running the transaction, zero
+	// without TrackCallers
+	Goroutine int64
+	// Stack is where WithTransaction was called, empty without TrackCallers
+	Stack string
+	// LastStatement is the last statement run in the transaction, empty if none
+	// or if the manager does not capture statements, see CaptureStatements. A
+	// statement still running is not known until it completes.
+	LastStatement string
+}
+
+// ActiveTransactions returns the transactions open in this process, oldest first
+func ActiveTransactions() []ActiveTx {
+	var txs []ActiveTx
+	activeTxs.Range(func(key, _ interface{}) bool {
+		state := key.(*txState)
+		state.mu.Lock()
+		last := state.lastStatement
+		state.mu.Unlock()
+
+		txs = append(txs, ActiveTx{
+			Info:          state.snapshot(),
+			Goroutine:     state.goroutine,
+			Stack:         formatStack(state.callers),
+			LastStatement: last,
+		})
+		return true
+	})
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Info.StartTime.Before(txs[j].Info.StartTime)
+	})
+	return txs
+}
+
+// TrackCallers records the goroutine and the caller stack of the transactions
+// reported by ActiveTransactions. It costs a few microseconds per transaction.
+func TrackCallers() Option {
+	return func(c *config) {
+		c.trackCallers = true
+	}
+}
+
+// track registers a top-level transaction as active until untrack
+func (s *txState) track() {
+	if s.parent != nil {
+		return
+	}
+	if s.config.trackCallers || s.config.slowThreshold > 0 {
+		s.goroutine = goroutineID()
+		s.callers = callers()
+	}
+	activeTxs.Store(s, struct{}{})
+}
+
+func (s *txState) untrack() {
+	if s.parent == nil {
+		activeTxs.Delete(s)
+	}
+}
+
+// goroutineID parses the id of the current goroutine from its stack header,
+// "goroutine 42 [running]:"
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = bytes.TrimPrefix(buf[:runtime.Stack(buf, false)], []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package txmanager_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveTransactions(t *testing.T) {
+	b := gormV2Backend(t, getSqliteV2(t), txmanager.TrackCallers())
+
+	err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, b.Insert(ctx, "a"))
+
+		var active txmanager.ActiveTx
+		for _, tx := range txmanager.ActiveTransactions() {
+			if tx.Info.Name == "active" {
+				active = tx
+			}
+		}
+		require.Equal(t, "active", active.Info.Name)
+		require.NotZero(t, active.Goroutine)
+		require.True(t, strings.Contains(active.Stack, "TestActiveTransactions"), active.Stack)
+		require.Equal(t, "INSERT INTO `records` (`key`) VALUES (?)", active.LastStatement)
+
+		t.Run("Handler", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			txmanager.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/txmanager?format=json", nil))
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var page struct {
+				Transactions []txmanager.ActiveTx
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+			goroutines := map[string]int64{}
+			for _, tx := range page.Transactions {
+				goroutines[tx.Info.Name] = tx.Goroutine
+			}
+			require.Equal(t, active.Goroutine, goroutines["active"])
+
+			rec = httptest.NewRecorder()
+			txmanager.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/txmanager", nil))
+			require.Contains(t, rec.Body.String(), "<td>active</td>")
+		})
+		return nil
+	}, txmanager.Name("active"), txmanager.TrackCallers())
+	require.NoError(t, err)
+
+	for _, tx := range txmanager.ActiveTransactions() {
+		require.NotEqual(t, "active", tx.Info.Name)
+	}
+}
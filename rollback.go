@@ -0,0 +1,83 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// ErrorMatcher reports whether err matches a rollback rule
+type ErrorMatcher func(err error) bool
+
+// ErrorIs matches errors for which errors.Is(err, target) is true
+func ErrorIs(target error) ErrorMatcher {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// ErrorAs matches errors for which errors.As would find an error of the type
+// target points to. Like errors.As target must be a non-nil pointer to a type
+// implementing error or to an interface, ErrorAs panics otherwise.
+func ErrorAs(target interface{}) ErrorMatcher {
+	typ := reflect.TypeOf(target)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic("txmanager: ErrorAs target must be a non-nil pointer")
+	}
+	if elem := typ.Elem(); elem.Kind() != reflect.Interface && !elem.Implements(errorType) {
+		panic("txmanager: ErrorAs target must point to an interface or to a type implementing error")
+	}
+	return func(err error) bool {
+		return errors.As(err, reflect.New(typ.Elem()).Interface())
+	}
+}
+
+// RollbackOn rolls the transaction back on errors matching one of matchers,
+// even when they also match NoRollbackOn or NoRollbackFor
+func RollbackOn(matchers ...ErrorMatcher) Option {
+	return func(c *config) {
+		c.rollbackOn = append(c.rollbackOn, matchers...)
+	}
+}
+
+// NoRollbackOn commits the transaction when the TxFn returns an error matching
+// one of matchers, the error is still returned by WithTransaction. A transaction
+// marked with SetRollbackOnly is rolled back nonetheless.
+func NoRollbackOn(matchers ...ErrorMatcher) Option {
+	return func(c *config) {
+		c.noRollbackFor = append(c.noRollbackFor, matchers...)
+	}
+}
+
+// NoRollbackFor is NoRollbackOn matching errs with errors.Is
+func NoRollbackFor(errs ...error) Option {
+	matchers := make([]ErrorMatcher, len(errs))
+	for i, err := range errs {
+		matchers[i] = ErrorIs(err)
+	}
+	return NoRollbackOn(matchers...)
+}
+
+// shouldRollback reports whether err returned by a TxFn rolls the transaction
+// back. Panics and cancellation always do.
+func (c config) shouldRollback(ctx context.Context, err error) bool {
+	var pe *PanicError
+	if errors.As(err, &pe) || ctx.Err() != nil {
+		return true
+	}
+	if matchAny(c.rollbackOn, err) {
+		return true
+	}
+	return !matchAny(c.noRollbackFor, err)
+}
+
+func matchAny(matchers []ErrorMatcher, err error) bool {
+	for _, match := range matchers {
+		if match(err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type validationError struct {
+	field string
+}
+
+func (e *validationError) Error() string {
+	return "invalid " + e.field
+}
+
+func TestErrorMatcher(t *testing.T) {
+	errProcessed := errors.New("already processed")
+
+	t.Run("ErrorIs", func(t *testing.T) {
+		match := txmanager.ErrorIs(errProcessed)
+		require.True(t, match(errProcessed))
+		require.True(t, match(fmt.Errorf("order 1: %w", errProcessed)))
+		require.False(t, match(errors.New("already processed")))
+	})
+
+	t.Run("ErrorAs", func(t *testing.T) {
+		var target *validationError
+		match := txmanager.ErrorAs(&target)
+		require.True(t, match(&validationError{field: "name"}))
+		require.True(t, match(fmt.Errorf("book: %w", &validationError{field: "name"})))
+		require.False(t, match(errProcessed))
+		require.Nil(t, target)
+	})
+
+	t.Run("ErrorAsInvalidTarget", func(t *testing.T) {
+		require.Panics(t, func() {
+			txmanager.ErrorAs(validationError{})
+		})
+		// errors.As would panic on the first failed transaction
+		require.Panics(t, func() {
+			var target validationError
+			txmanager.ErrorAs(&target)
+		})
+		require.Panics(t, func() {
+			var target string
+			txmanager.ErrorAs(&target)
+		})
+		require.NotPanics(t, func() {
+			var target interface{ Timeout() bool }
+			txmanager.ErrorAs(&target)
+		})
+	})
+}
+
+func TestNoRollbackOn(t *testing.T) {
+	var target *validationError
+	b := gormV2Backend(t, getSqliteV2(t), txmanager.NoRollbackOn(txmanager.ErrorAs(&target)))
+
+	err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := b.Insert(ctx, "a"); err != nil {
+			return err
+		}
+		return fmt.Errorf("book: %w", &validationError{field: "name"})
+	})
+	require.EqualError(t, err, "book: invalid name")
+	exists, err := b.Exists("a")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// other errors still roll back
+	err = b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := b.Insert(ctx, "b"); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	require.EqualError(t, err, "failed")
+	exists, err = b.Exists("b")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
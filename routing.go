@@ -0,0 +1,152 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownTenant is returned by RoutingTxManager when no TxManager is
+	// registered for the tenant of the ctx
+	ErrUnknownTenant = errors.New("txmanager: unknown tenant")
+	// ErrTenantMismatch is returned by RoutingTxManager when the ctx is already
+	// in a transaction of another tenant
+	ErrTenantMismatch = errors.New("txmanager: transaction of another tenant")
+)
+
+// TenantResolver returns the tenant of ctx
+type TenantResolver func(ctx context.Context) string
+
+// RoutingTxManager runs every transaction on the TxManager registered for the
+// tenant of its ctx, for a database or a schema per tenant. A transaction of a
+// tenant is never reused by another one: WithTransaction fails with
+// ErrTenantMismatch when the ctx is already in a transaction of another tenant.
+type RoutingTxManager struct {
+	resolve TenantResolver
+
+	mu       sync.RWMutex
+	managers map[string]TxManager
+}
+
+type tenantKey struct{}
+
+// NewRoutingTxManager create RoutingTxManager resolving the tenant of a ctx with
+// resolve and routing its transactions to managers
+func NewRoutingTxManager(resolve TenantResolver, managers map[string]TxManager) *RoutingTxManager {
+	r := &RoutingTxManager{resolve: resolve, managers: make(map[string]TxManager, len(managers))}
+	for tenant, m := range managers {
+		r.managers[tenant] = m
+	}
+	return r
+}
+
+// Register routes the transactions of tenant to m, replacing the TxManager
+// registered before
+func (r *RoutingTxManager) Register(tenant string, m TxManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managers[tenant] = m
+}
+
+// Deregister stops routing the transactions of tenant, the in-flight ones are
+// left untouched
+func (r *RoutingTxManager) Deregister(tenant string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.managers, tenant)
+}
+
+// Manager returns the TxManager of the tenant of ctx
+func (r *RoutingTxManager) Manager(ctx context.Context) (TxManager, error) {
+	return r.manager(r.resolve(ctx))
+}
+
+func (r *RoutingTxManager) manager(tenant string) (TxManager, error) {
+	r.mu.RLock()
+	m, ok := r.managers[tenant]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, tenant)
+	}
+	return m, nil
+}
+
+func (r *RoutingTxManager) WithTransaction(ctx context.Context, txfn TxFn, opts ...Option) error {
+	ctx, m, err := r.route(ctx)
+	if err != nil {
+		return err
+	}
+	return m.WithTransaction(ctx, txfn, opts...)
+}
+
+// WithSession runs fn in a session of the TxManager of the tenant of ctx when it
+// is a SessionManager
+func (r *RoutingTxManager) WithSession(ctx context.Context, fn TxFn) error {
+	ctx, m, err := r.route(ctx)
+	if err != nil {
+		return err
+	}
+	if s, ok := m.(SessionManager); ok {
+		return s.WithSession(ctx, fn)
+	}
+	return errors.New("txmanager: sessions are not supported by the TxManager of the tenant")
+}
+
+// Shutdown shuts down every registered TxManager that is a Shutdowner, returning
+// the first error
+func (r *RoutingTxManager) Shutdown(ctx context.Context) error {
+	r.mu.RLock()
+	tenants := make([]string, 0, len(r.managers))
+	for tenant := range r.managers {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	managers := make([]TxManager, len(tenants))
+	for i, tenant := range tenants {
+		managers[i] = r.managers[tenant]
+	}
+	r.mu.RUnlock()
+
+	var first error
+	for _, m := range managers {
+		if s, ok := m.(Shutdowner); ok {
+			if err := s.Shutdown(ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// route returns the TxManager of the tenant of ctx and ctx carrying the tenant
+func (r *RoutingTxManager) route(ctx context.Context) (context.Context, TxManager, error) {
+	tenant := r.resolve(ctx)
+	if current, ok := Tenant(ctx); ok && current != tenant && InTransaction(ctx) {
+		return nil, nil, fmt.Errorf("%w: in a transaction of %q, not %q", ErrTenantMismatch, current, tenant)
+	}
+	m, err := r.manager(tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant), m, nil
+}
+
+// Tenant returns the tenant a RoutingTxManager routed ctx to
+func Tenant(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// SearchPath sets the search_path of the Postgres transactions to schemas, for a
+// schema per tenant on a shared database. The schemas are used as is, quote the
+// names that are not lower case identifiers. The transactions fail to begin on
+// the other databases of the built-in drivers.
+func SearchPath(schemas ...string) Option {
+	return SessionVars(map[string]interface{}{searchPathVar: strings.Join(schemas, ", ")})
+}
+
+const searchPathVar = "search_path"
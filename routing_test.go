@@ -0,0 +1,103 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+	gormv2 "gorm.io/gorm"
+)
+
+type routeKey struct{}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, routeKey{}, tenant)
+}
+
+func resolveTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(routeKey{}).(string)
+	return tenant
+}
+
+func TestRoutingTxManager(t *testing.T) {
+	dbs := map[string]*gormv2.DB{"a": getSqliteV2(t), "b": getSqliteV2(t)}
+	managers := map[string]txmanager.TxManager{}
+	for tenant, db := range dbs {
+		require.NoError(t, db.AutoMigrate(&record{}))
+		managers[tenant] = txmanager.NewGormTxManager(db, txmanager.Nested())
+	}
+	router := txmanager.NewRoutingTxManager(resolveTenant, managers)
+
+	count := func(tenant string) int64 {
+		var n int64
+		require.NoError(t, dbs[tenant].Model(&record{}).Count(&n).Error)
+		return n
+	}
+
+	t.Run("RoutedToTenant", func(t *testing.T) {
+		err := router.WithTransaction(withTenant(context.Background(), "a"), func(ctx context.Context) error {
+			tenant, ok := txmanager.Tenant(ctx)
+			require.True(t, ok)
+			require.Equal(t, "a", tenant)
+			return txmanager.GetTxConnV2(ctx).Create(&record{Key: "routed"}).Error
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count("a"))
+		require.Equal(t, int64(0), count("b"))
+	})
+
+	t.Run("NestedSameTenant", func(t *testing.T) {
+		err := router.WithTransaction(withTenant(context.Background(), "b"), func(ctx context.Context) error {
+			return router.WithTransaction(ctx, func(ctx context.Context) error {
+				info, _ := txmanager.Info(ctx)
+				require.Equal(t, 1, info.Depth)
+				return nil
+			})
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("OtherTenantInTransaction", func(t *testing.T) {
+		err := router.WithTransaction(withTenant(context.Background(), "a"), func(ctx context.Context) error {
+			return router.WithTransaction(withTenant(ctx, "b"), func(ctx context.Context) error {
+				t.Fatal("expected the transaction of b not to run")
+				return nil
+			})
+		})
+		require.True(t, errors.Is(err, txmanager.ErrTenantMismatch), err)
+	})
+
+	t.Run("UnknownTenant", func(t *testing.T) {
+		err := router.WithTransaction(withTenant(context.Background(), "c"), func(ctx context.Context) error {
+			return nil
+		})
+		require.True(t, errors.Is(err, txmanager.ErrUnknownTenant), err)
+
+		router.Register("c", txmanager.NewGormTxManager(getSqliteV2(t)))
+		_, err = router.Manager(withTenant(context.Background(), "c"))
+		require.NoError(t, err)
+		router.Deregister("c")
+		_, err = router.Manager(withTenant(context.Background(), "c"))
+		require.True(t, errors.Is(err, txmanager.ErrUnknownTenant), err)
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		require.NoError(t, router.Shutdown(context.Background()))
+		err := router.WithTransaction(withTenant(context.Background(), "a"), func(ctx context.Context) error {
+			return nil
+		})
+		require.True(t, errors.Is(err, txmanager.ErrManagerClosed), err)
+	})
+}
+
+func TestSearchPath(t *testing.T) {
+	var calls []map[string]interface{}
+	err := txmanager.NewTxManager(varsDriver{calls: &calls}, txmanager.SearchPath("tenant_a", "public")).
+		WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"search_path": "tenant_a, public"}, calls[0])
+}
@@ -0,0 +1,188 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+	gormv2 "gorm.io/gorm"
+)
+
+// SavepointTx is implemented by a Tx supporting savepoints. WithTransaction
+// called with a ctx already in a transaction of the same Driver then runs as a
+// savepoint of that transaction instead of beginning a new one.
+type SavepointTx interface {
+	Tx
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Release(name string) error
+}
+
+// pooledDriver is implemented by the built-in drivers, whose transactions can
+// be joined by any driver sharing the same connection pool
+type pooledDriver interface {
+	pool() interface{}
+}
+
+// sameDriver reports whether a transaction begun by a can be joined through b
+func sameDriver(a, b Driver) bool {
+	if pa, ok := a.(pooledDriver); ok {
+		pb, ok := b.(pooledDriver)
+		return ok && pa.pool() == pb.pool()
+	}
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// savepoint is the Tx of a nested transaction, it can be nested further
+type savepoint struct {
+	SavepointTx
+	name string
+}
+
+func (s savepoint) Commit() error   { return s.Release(s.name) }
+func (s savepoint) Rollback() error { return s.RollbackTo(s.name) }
+
+// beginNested starts a savepoint for state in the transaction of its parent
+func beginNested(ctx context.Context, state *txState) (context.Context, Tx, error) {
+	sp, ok := state.parent.tx.(SavepointTx)
+	if !ok {
+		return nil, nil, fmt.Errorf("txmanager: %T does not support savepoints", state.parent.tx)
+	}
+	state.savepoint = fmt.Sprintf("txmanager_sp_%d", atomic.AddInt64(&state.root().savepoints, 1))
+	if err := sp.Savepoint(state.savepoint); err != nil {
+		return nil, nil, err
+	}
+	return ctx, savepoint{SavepointTx: sp, name: state.savepoint}, nil
+}
+
+func (t gormTx) Savepoint(name string) error { return t.db.Exec("SAVEPOINT " + name).Error }
+func (t gormTx) RollbackTo(name string) error {
+	return t.db.Exec("ROLLBACK TO SAVEPOINT " + name).Error
+}
+func (t gormTx) Release(name string) error { return t.db.Exec("RELEASE SAVEPOINT " + name).Error }
+
+func (t gormV2Tx) Savepoint(name string) error  { return t.exec("SAVEPOINT " + name) }
+func (t gormV2Tx) RollbackTo(name string) error { return t.exec("ROLLBACK TO SAVEPOINT " + name) }
+func (t gormV2Tx) Release(name string) error    { return t.exec("RELEASE SAVEPOINT " + name) }
+
+// exec runs query on the connection, bypassing the gorm callbacks so that it is
+// not reported as a statement of the transaction
+func (t gormV2Tx) exec(query string, args ...interface{}) error {
+	_, err := t.db.Statement.ConnPool.ExecContext(t.db.Statement.Context, query, args...)
+	return err
+}
+
+type gormPool struct {
+	pool gorm.SQLCommon
+}
+
+type gormV2Pool struct {
+	pool gormv2.ConnPool
+}
+
+func (d gormDriver) pool() interface{}   { return gormPool{d.db.CommonDB()} }
+func (d gormV2Driver) pool() interface{} { return gormV2Pool{d.db.Statement.ConnPool} }
+
+var (
+	// ErrNoTransaction is returned by the savepoint functions called with a
+	// ctx outside of a transaction
+	ErrNoTransaction = errors.New("txmanager: no transaction in ctx")
+	// ErrInvalidSavepointName is returned by the savepoint functions when the
+	// name is not a plain identifier accepted by the database
+	ErrInvalidSavepointName = errors.New("txmanager: invalid savepoint name")
+)
+
+// savepointName matches the names safe to splice into a savepoint statement
+var savepointName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// maxSavepointName is the longest identifier of each dialect
+var maxSavepointName = map[string]int{
+	"mysql":    64,
+	"postgres": 63,
+}
+
+// dialectTx is implemented by the Tx of the built-in drivers
+type dialectTx interface {
+	dialect() string
+}
+
+func (t gormTx) dialect() string   { return t.db.Dialect().GetName() }
+func (t gormV2Tx) dialect() string { return t.db.Dialector.Name() }
+
+// savepointTx returns the innermost transaction of ctx, checking that name can
+// be used as a savepoint
+func savepointTx(ctx context.Context, name string) (*txState, SavepointTx, error) {
+	state := getTxState(ctx)
+	if state == nil {
+		return nil, nil, ErrNoTransaction
+	}
+	sp, ok := state.tx.(SavepointTx)
+	if !ok {
+		return nil, nil, fmt.Errorf("txmanager: %T does not support savepoints", state.tx)
+	}
+
+	if !savepointName.MatchString(name) || strings.HasPrefix(name, "txmanager_sp_") {
+		return nil, nil, fmt.Errorf("%w %q, want letters, digits and underscores, not prefixed by txmanager_sp_", ErrInvalidSavepointName, name)
+	}
+	if d, ok := state.root().tx.(dialectTx); ok {
+		if max := maxSavepointName[d.dialect()]; max > 0 && len(name) > max {
+			return nil, nil, fmt.Errorf("%w %q, longer than %d characters on %s", ErrInvalidSavepointName, name, max, d.dialect())
+		}
+	}
+	return state, sp, nil
+}
+
+// Savepoint sets a savepoint named name in the transaction of ctx
+func Savepoint(ctx context.Context, name string) error {
+	state, sp, err := savepointTx(ctx, name)
+	if err != nil {
+		return err
+	}
+	state.record(Entry{Kind: EntrySavepoint, SQL: name})
+	return sp.Savepoint(name)
+}
+
+// RollbackTo rolls the transaction of ctx back to the savepoint named name,
+// which is kept
+func RollbackTo(ctx context.Context, name string) error {
+	state, sp, err := savepointTx(ctx, name)
+	if err != nil {
+		return err
+	}
+	state.record(Entry{Kind: EntryRollbackTo, SQL: name})
+	return sp.RollbackTo(name)
+}
+
+// Release removes the savepoint named name from the transaction of ctx, keeping
+// the work done since
+func Release(ctx context.Context, name string) error {
+	state, sp, err := savepointTx(ctx, name)
+	if err != nil {
+		return err
+	}
+	state.record(Entry{Kind: EntryRelease, SQL: name})
+	return sp.Release(name)
+}
+
+// WithSavepoint runs fn in a savepoint of the transaction of ctx, with a
+// generated name, as WithTransaction with Nested would. When fn fails only its
+// work is rolled back and its error returned, the transaction goes on, which
+// suits skipping the bad rows of a batch.
+func WithSavepoint(ctx context.Context, fn TxFn) error {
+	state := getTxState(ctx)
+	if state == nil {
+		return ErrNoTransaction
+	}
+	root := state.root()
+	cfg := config{manager: root.config.manager, listeners: root.config.listeners, nested: true}
+	return runTx(ctx, cfg, state.driver, fn)
+}
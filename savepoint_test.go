@@ -0,0 +1,103 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavepoint(t *testing.T) {
+	t.Run("Explicit", func(t *testing.T) {
+		b := gormV2Backend(t, getSqliteV2(t), txmanager.CaptureStatements())
+		rec := &txmanager.Recorder{}
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, b.Insert(ctx, "a"))
+			require.NoError(t, txmanager.Savepoint(ctx, "before_b"))
+			require.NoError(t, b.Insert(ctx, "b"))
+			require.NoError(t, txmanager.RollbackTo(ctx, "before_b"))
+			require.NoError(t, b.Insert(ctx, "c"))
+			return txmanager.Release(ctx, "before_b")
+		}, txmanager.Record(rec))
+		require.NoError(t, err)
+
+		for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+			exists, err := b.Exists(key)
+			require.NoError(t, err)
+			require.Equal(t, want, exists, key)
+		}
+		require.Contains(t, rec.Transcript(), "SAVEPOINT before_b\n")
+		require.Contains(t, rec.Transcript(), "ROLLBACK TO SAVEPOINT before_b\n")
+		require.Contains(t, rec.Transcript(), "RELEASE SAVEPOINT before_b\n")
+	})
+
+	t.Run("WithSavepointSkipsBadRows", func(t *testing.T) {
+		b := gormBackend(t, getSqlite(t))
+		var skipped []string
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			for _, key := range []string{"a", "b", "a", "c"} {
+				err := txmanager.WithSavepoint(ctx, func(ctx context.Context) error {
+					return b.Insert(ctx, key)
+				})
+				if err != nil {
+					skipped = append(skipped, key)
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, skipped)
+
+		for _, key := range []string{"a", "b", "c"} {
+			exists, err := b.Exists(key)
+			require.NoError(t, err)
+			require.True(t, exists, key)
+		}
+	})
+
+	t.Run("WithSavepointDropsCommitHooks", func(t *testing.T) {
+		b := gormV2Backend(t, getSqliteV2(t))
+		var hooks []string
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_ = txmanager.WithSavepoint(ctx, func(ctx context.Context) error {
+				txmanager.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "rolled back") })
+				return errors.New("failed")
+			})
+			return txmanager.WithSavepoint(ctx, func(ctx context.Context) error {
+				txmanager.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "released") })
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"released"}, hooks)
+	})
+
+	t.Run("OutsideTransaction", func(t *testing.T) {
+		ctx := context.Background()
+		require.Equal(t, txmanager.ErrNoTransaction, txmanager.Savepoint(ctx, "sp"))
+		require.Equal(t, txmanager.ErrNoTransaction, txmanager.RollbackTo(ctx, "sp"))
+		require.Equal(t, txmanager.ErrNoTransaction, txmanager.Release(ctx, "sp"))
+		require.Equal(t, txmanager.ErrNoTransaction, txmanager.WithSavepoint(ctx, func(context.Context) error {
+			return nil
+		}))
+	})
+
+	t.Run("InvalidNames", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			for _, name := range []string{"", "1sp", "sp; DROP TABLE records", "sp-1", "txmanager_sp_1"} {
+				err := txmanager.Savepoint(ctx, name)
+				require.True(t, errors.Is(err, txmanager.ErrInvalidSavepointName), "%q: %v", name, err)
+			}
+			// SQLite does not limit identifiers
+			return txmanager.Savepoint(ctx, strings.Repeat("s", 100))
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,324 @@
+package txmanager
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	gormv2 "gorm.io/gorm"
+)
+
+// SessionManager is implemented by the gorm managers of this package
+type SessionManager interface {
+	// WithSession runs fn with a dedicated connection and no transaction, for
+	// work bound to a connection such as GET_LOCK, temporary tables, session
+	// variables or LOCK TABLES. GetTxConn and GetTxConnV2 return a handle on
+	// the connection, and WithTransaction begins on it unless a transaction is
+	// already open on it, then it begins on the pool. The session state is
+	// reset before the connection goes back to the pool: with DISCARD ALL on
+	// Postgres, by closing the connection on the other databases.
+	WithSession(ctx context.Context, fn TxFn) error
+}
+
+type sessionKey struct{}
+
+// session is a connection checked out by WithSession
+type session struct {
+	pool interface{}
+	db   *gorm.DB
+	dbV2 *gormv2.DB
+	// inTx is set while a transaction is open on the connection
+	inTx int32
+}
+
+// idle reports whether s is a session with no transaction open on its
+// connection
+func (s *session) idle() bool {
+	return s != nil && atomic.LoadInt32(&s.inTx) == 0
+}
+
+// acquire marks a transaction open on the connection of s, it reports false
+// when one already is
+func (s *session) acquire() bool {
+	return atomic.CompareAndSwapInt32(&s.inTx, 0, 1)
+}
+
+func (s *session) release() {
+	atomic.StoreInt32(&s.inTx, 0)
+}
+
+// getSession returns the session of ctx on pool, nil if none
+func getSession(ctx context.Context, pool interface{}) *session {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok && s.pool == pool {
+		return s
+	}
+	return nil
+}
+
+// runSession checks a connection out of db, runs fn with it then resets it. A
+// ctx already pinned to a connection of the pool, by a session or a
+// transaction, is reused.
+func runSession(ctx context.Context, db *sql.DB, dialect string, s *session, fn TxFn) error {
+	if pinned(ctx, s.pool) {
+		return fn(ctx)
+	}
+	ctx, conn, err := openSession(ctx, db, s)
+	if err != nil {
+		return err
+	}
+	defer resetSession(conn, dialect)
+	return fn(ctx)
+}
+
+// openSession checks a connection out of db for s, and returns ctx carrying s
+// and a handle on the connection
+func openSession(ctx context.Context, db *sql.DB, s *session) (context.Context, *sql.Conn, error) {
+	if db == nil {
+		return nil, nil, errors.New("txmanager: sessions need a handle on a *sql.DB")
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = context.WithValue(ctx, sessionKey{}, s)
+	if s.db != nil {
+		s.db = withCommonDB(s.db, sessionConn{ctx: ctx, conn: conn})
+		return setTxConn(ctx, s.db), conn, nil
+	}
+	s.dbV2 = s.dbV2.WithContext(ctx)
+	s.dbV2.Statement.ConnPool = conn
+	return setTxConnV2(ctx, s.dbV2), conn, nil
+}
+
+// pinDriver is implemented by the built-in drivers, pin checks a connection out
+// of their pool for a transaction. Begin begins on the connection pinned by the
+// txState of its ctx.
+type pinDriver interface {
+	pooledDriver
+	dialect() string
+	pin(ctx context.Context) (*sql.Conn, error)
+}
+
+func (d gormDriver) dialect() string   { return d.db.Dialect().GetName() }
+func (d gormV2Driver) dialect() string { return d.db.Dialector.Name() }
+
+func (d gormDriver) pin(ctx context.Context) (*sql.Conn, error) {
+	return d.db.DB().Conn(ctx)
+}
+
+func (d gormV2Driver) pin(ctx context.Context) (*sql.Conn, error) {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return sqlDB.Conn(ctx)
+}
+
+// pinnedConn returns the connection pinned for the transaction begun with ctx,
+// nil if none. It is private to the transaction, its ctx carries no session so
+// that the transactions begun inside it do not begin on its connection.
+func pinnedConn(ctx context.Context) *sql.Conn {
+	if state := getTxState(ctx); state != nil {
+		return state.pinned
+	}
+	return nil
+}
+
+// sessionOf returns the session the transaction begun with ctx begins on, nil
+// if none
+func sessionOf(ctx context.Context) *session {
+	if state := getTxState(ctx); state != nil {
+		return state.session
+	}
+	return nil
+}
+
+// pinsConn reports whether a top-level transaction of cfg leaves state on its
+// connection once it ended, on the given dialect
+func (c config) pinsConn(dialect string) bool {
+	return dialect == "mysql" && (c.maxDuration > 0 || len(c.vars) > 0)
+}
+
+// pin begins the transaction on the connection of the session of ctx when no
+// transaction is open on it. Otherwise it begins the transaction on a
+// connection of its own when it leaves state on it, so that the state can be
+// restored once the transaction ended, even when it was aborted with its ctx.
+func (s *txState) pin(ctx context.Context) error {
+	d, ok := s.driver.(pinDriver)
+	if !ok {
+		return nil
+	}
+	if sess := getSession(ctx, d.pool()); sess != nil && sess.acquire() {
+		// a session discards its connection
+		s.session = sess
+		return nil
+	}
+	if !s.config.pinsConn(d.dialect()) {
+		return nil
+	}
+	conn, err := d.pin(ctx)
+	if err != nil {
+		return err
+	}
+	s.pinned = conn
+	s.dialect = d.dialect()
+	return nil
+}
+
+// unpin restores the state left by the transaction on its connection, then
+// releases the connection. It is discarded when the state cannot be restored.
+func (s *txState) unpin() {
+	if s.session != nil {
+		s.session.release()
+	}
+	if s.pinned == nil {
+		return
+	}
+	defer s.pinned.Close()
+
+	var (
+		stmts []string
+		args  [][]interface{}
+	)
+	if s.timeout {
+		for _, stmt := range timeoutStatements(s.dialect, 0) {
+			stmts = append(stmts, stmt)
+			args = append(args, nil)
+		}
+	}
+	if len(s.vars) > 0 {
+		varStmts, varArgs, err := varsStatements(s.dialect, s.clearedVars())
+		if err != nil {
+			discardConn(s.pinned)
+			return
+		}
+		stmts = append(stmts, varStmts...)
+		args = append(args, varArgs...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+	for i, stmt := range stmts {
+		if _, err := s.pinned.ExecContext(ctx, stmt, args[i]...); err != nil {
+			if !errors.Is(err, sql.ErrConnDone) {
+				logrus.Warnf("txmanager: cannot restore the session of transaction %q, discarding its connection: %v", s.info.Name, err)
+			}
+			discardConn(s.pinned)
+			return
+		}
+	}
+}
+
+// resetTimeout bounds the statements restoring the state of a connection
+const resetTimeout = 5 * time.Second
+
+// pinned reports whether ctx holds a connection of pool, in a session or a
+// transaction
+func pinned(ctx context.Context, pool interface{}) bool {
+	if getSession(ctx, pool) != nil {
+		return true
+	}
+	if state := getTxState(ctx); state != nil {
+		if d, ok := state.driver.(pooledDriver); ok && d.pool() == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// resetSession clears the state left on conn, then releases it
+func resetSession(conn *sql.Conn, dialect string) {
+	defer conn.Close()
+	if dialect == "postgres" {
+		if _, err := conn.ExecContext(context.Background(), "DISCARD ALL"); err == nil {
+			return
+		}
+	}
+	// no statement resets a whole session, discard the connection
+	discardConn(conn)
+}
+
+// discardConn makes conn be closed rather than going back to the pool
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+}
+
+func (g *GormTxManager) WithSession(ctx context.Context, fn TxFn) error {
+	d := gormDriver{g.db}
+	return g.gate.run(ctx, "", pinned(ctx, d.pool()), func(ctx context.Context) error {
+		return runSession(ctx, g.db.DB(), g.db.Dialect().GetName(), &session{pool: d.pool(), db: g.db}, fn)
+	})
+}
+
+func (g *GormV2TxManager) WithSession(ctx context.Context, fn TxFn) error {
+	d := gormV2Driver{g.db}
+	return g.gate.run(ctx, "", pinned(ctx, d.pool()), func(ctx context.Context) error {
+		sqlDB, err := g.db.DB()
+		if err != nil {
+			return err
+		}
+		return runSession(ctx, sqlDB, g.db.Dialector.Name(), &session{pool: d.pool(), dbV2: g.db}, fn)
+	})
+}
+
+// withCommonDB returns a clone of db running its statements on conn. The clone
+// keeps the configuration of db, its callbacks, logger and table naming, and
+// its search scopes such as Unscoped. gorm v1 has no API for it, Set is the
+// public way to clone a handle and the connection is set through reflection as
+// gorm does on BeginTx.
+func withCommonDB(db *gorm.DB, conn gorm.SQLCommon) *gorm.DB {
+	clone := db.Set(reboundSetting, true)
+	setCommonDB(clone, conn)
+	return clone
+}
+
+// reboundSetting marks the handles rebound to another connection
+const reboundSetting = "txmanager:rebound"
+
+// setCommonDB makes db run its statements on conn
+func setCommonDB(db *gorm.DB, conn gorm.SQLCommon) {
+	field := reflect.ValueOf(db).Elem().FieldByName("db")
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(conn))
+	db.Dialect().SetDB(conn)
+}
+
+// sessionConn adapts a *sql.Conn to the gorm v1 connection interfaces, gorm v1
+// does not pass a ctx to its statements
+type sessionConn struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c sessionConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c sessionConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(c.ctx, query)
+}
+
+func (c sessionConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c sessionConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c sessionConn) Begin() (*sql.Tx, error) {
+	return c.conn.BeginTx(c.ctx, nil)
+}
+
+func (c sessionConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
@@ -0,0 +1,146 @@
+package txmanager_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+	sqlitev2 "gorm.io/driver/sqlite"
+	gormv2 "gorm.io/gorm"
+)
+
+func TestWithSession(t *testing.T) {
+	t.Run("GormV2", func(t *testing.T) {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		// a single connection, so that the next session gets the same one
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		txManager := txmanager.NewGormTxManager(db)
+		sessions := txManager.(txmanager.SessionManager)
+
+		err = sessions.WithSession(context.Background(), func(ctx context.Context) error {
+			require.False(t, txmanager.InTransaction(ctx))
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Exec("CREATE TEMP TABLE session_keys (key TEXT)").Error)
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Exec("INSERT INTO session_keys VALUES ('a')").Error)
+
+			// transactions begin on the connection of the session
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				return txmanager.GetTxConnV2(ctx).Exec("INSERT INTO records SELECT key FROM session_keys").Error
+			})
+			require.NoError(t, err)
+
+			// nested sessions reuse the connection
+			return sessions.WithSession(ctx, func(ctx context.Context) error {
+				var count int64
+				require.NoError(t, txmanager.GetTxConnV2(ctx).Table("session_keys").Count(&count).Error)
+				require.Equal(t, int64(1), count)
+				return nil
+			})
+		})
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&record{}).Where("key = ?", "a").Count(&count).Error)
+		require.Equal(t, int64(1), count)
+
+		// the session state does not leak to the next user of the pool
+		err = sessions.WithSession(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConnV2(ctx).Exec("CREATE TEMP TABLE session_keys (key TEXT)").Error
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Gorm", func(t *testing.T) {
+		db := getSqlite(t)
+		require.NoError(t, db.AutoMigrate(&record{}).Error)
+		db.DB().SetMaxOpenConns(1)
+		txManager := txmanager.StartTxManager(db, txmanager.Nested())
+		sessions := txManager.(txmanager.SessionManager)
+
+		err := sessions.WithSession(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConn(ctx).Exec("CREATE TEMP TABLE session_keys (key TEXT)").Error)
+			require.NoError(t, txmanager.GetTxConn(ctx).Exec("INSERT INTO session_keys VALUES ('a')").Error)
+
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				require.NoError(t, txmanager.GetTxConn(ctx).Exec("INSERT INTO records SELECT key FROM session_keys").Error)
+				// the transaction is nested as usual
+				return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					return txmanager.GetTxConn(ctx).Exec("DELETE FROM session_keys").Error
+				})
+			})
+			require.NoError(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, db.Model(&record{}).Where("key = ?", "a").Count(&count).Error)
+		require.Equal(t, 1, count)
+
+		err = sessions.WithSession(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConn(ctx).Exec("CREATE TEMP TABLE session_keys (key TEXT)").Error
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("GormKeepsConfiguration", func(t *testing.T) {
+		db := getSqlite(t)
+		db.SingularTable(true)
+		require.NoError(t, db.AutoMigrate(&record{}).Error)
+		txManager := txmanager.StartTxManager(db)
+
+		err := txManager.(txmanager.SessionManager).WithSession(context.Background(), func(ctx context.Context) error {
+			// the session handle names tables as the db of the manager
+			return txmanager.GetTxConn(ctx).Create(&record{Key: "a"}).Error
+		})
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, db.Model(&record{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("IndependentTransactionInside", func(t *testing.T) {
+		// deferred transactions, so that the independent transaction can begin
+		// while the one of the session is open
+		db, err := gormv2.Open(sqlitev2.Open(filepath.Join(t.TempDir(), "txmanager.db")+"?_journal_mode=WAL&_busy_timeout=5000"), &gormv2.Config{})
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		txManager := txmanager.NewGormTxManager(db)
+
+		err = txManager.(txmanager.SessionManager).WithSession(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Exec("CREATE TEMP TABLE session_keys (key TEXT)").Error)
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				// a transaction is open on the connection of the session, this
+				// one begins on the pool
+				err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					require.Error(t, txmanager.GetTxConnV2(ctx).Exec("SELECT * FROM session_keys").Error)
+					return txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error
+				})
+				require.NoError(t, err)
+				return txmanager.GetTxConnV2(ctx).Create(&record{Key: "b"}).Error
+			})
+		})
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&record{}).Count(&count).Error)
+		require.Equal(t, int64(2), count)
+	})
+
+	t.Run("InsideTransaction", func(t *testing.T) {
+		b := gormV2Backend(t, getSqliteV2(t))
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return b.Manager.(txmanager.SessionManager).WithSession(ctx, func(sessionCtx context.Context) error {
+				// the connection of the transaction is reused
+				require.True(t, txmanager.InTransaction(sessionCtx))
+				return b.Insert(sessionCtx, "a")
+			})
+		})
+		require.NoError(t, err)
+	})
+}
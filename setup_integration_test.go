@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
-	"github.com/shortlyst-ai/go-txmanager"
+	"github.com/shortlyst-ai/go-txmanager/v2"
 	"github.com/stretchr/testify/require"
 	mysqlv2 "gorm.io/driver/mysql"
 	gormv2 "gorm.io/gorm"
@@ -121,6 +122,50 @@ func stringPointer(str string) *string {
 	return &str
 }
 
+// recordingListener records the lifecycle events it receives
+type recordingListener struct {
+	mu     sync.Mutex
+	events []string
+	infos  []txmanager.TxInfo
+}
+
+func (l *recordingListener) record(event string, info txmanager.TxInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	l.infos = append(l.infos, info)
+}
+
+func (l *recordingListener) Events() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func (l *recordingListener) OnBegin(_ context.Context, info txmanager.TxInfo) {
+	l.record("begin", info)
+}
+
+func (l *recordingListener) OnCommit(_ context.Context, info txmanager.TxInfo) {
+	l.record("commit", info)
+}
+
+func (l *recordingListener) OnRollback(_ context.Context, info txmanager.TxInfo) {
+	l.record("rollback", info)
+}
+
+func (l *recordingListener) OnPanic(_ context.Context, info txmanager.TxInfo) {
+	l.record("panic", info)
+}
+
+func (l *recordingListener) OnRetry(_ context.Context, info txmanager.TxInfo) {
+	l.record("retry", info)
+}
+
+func (l *recordingListener) OnCancel(_ context.Context, info txmanager.TxInfo) {
+	l.record("cancel", info)
+}
+
 type author struct {
 	ID   *int    `gorm:"primaryKey;autoIncrement" json:"id"`
 	Name *string `gorm:"unique" json:"name"`
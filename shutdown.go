@@ -0,0 +1,110 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrManagerClosed is returned by WithTransaction once Shutdown was called
+var ErrManagerClosed = errors.New("txmanager: manager is shut down")
+
+// Shutdowner is implemented by the managers of this package
+type Shutdowner interface {
+	// Shutdown rejects new transactions with ErrManagerClosed and waits for the
+	// in-flight ones, and their commit hooks, to end. When ctx is done first the
+	// remaining transactions are cancelled, so that they roll back, and
+	// Shutdown waits for their rollback, at most a few seconds, before
+	// returning the ctx error.
+	Shutdown(ctx context.Context) error
+}
+
+// gate tracks the in-flight transactions of a manager to drain them on
+// Shutdown, and admits them as configured by MaxConcurrent
+type gate struct {
+	admission *admission
+
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+	next     int64
+	cancels  map[int64]context.CancelFunc
+}
+
+func newGate(cfg config) *gate {
+	return &gate{admission: newAdmission(cfg), cancels: map[int64]context.CancelFunc{}}
+}
+
+// run runs fn as an in-flight transaction named name, or rejects it once
+// closed. Calls joining the connection held by ctx are part of its transaction
+// or session and always run.
+func (g *gate) run(ctx context.Context, name string, joins bool, fn func(ctx context.Context) error) error {
+	if joins {
+		return fn(ctx)
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return ErrManagerClosed
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := g.next
+	g.next++
+	g.cancels[id] = cancel
+	g.inflight.Add(1)
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.cancels, id)
+		g.mu.Unlock()
+		cancel()
+		g.inflight.Done()
+	}()
+
+	release, err := g.admission.acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+func (g *gate) shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		for _, cancel := range g.cancels {
+			cancel()
+		}
+		g.mu.Unlock()
+
+		// let the cancelled transactions roll back, a TxFn ignoring its ctx
+		// must not hold the process
+		timer := time.NewTimer(rollbackGrace)
+		defer timer.Stop()
+		select {
+		case <-drained:
+		case <-timer.C:
+		}
+		return ctx.Err()
+	}
+}
+
+// rollbackGrace bounds the wait for the rollback of the transactions cancelled
+// by Shutdown
+const rollbackGrace = 5 * time.Second
@@ -0,0 +1,120 @@
+package txmanager_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Run("DrainsInFlight", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager(txmanager.Nested())
+
+		began := make(chan struct{})
+		release := make(chan struct{})
+		nestedRan, hookRan := false, false
+		txErr := make(chan error, 1)
+		go func() {
+			txErr <- txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				close(began)
+				<-release
+				// nested calls belong to the in-flight transaction
+				err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					nestedRan = true
+					return nil
+				})
+				txmanager.AfterCommit(ctx, func(context.Context) {
+					hookRan = true
+				})
+				return err
+			})
+		}()
+		<-began
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- txManager.Shutdown(context.Background())
+		}()
+
+		// wait for the manager to be closed
+		for {
+			err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				return nil
+			})
+			if err == txmanager.ErrManagerClosed {
+				break
+			}
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond)
+		}
+
+		close(release)
+		require.NoError(t, <-shutdownErr)
+		require.True(t, hookRan, "commit hooks must run before Shutdown returns")
+		require.True(t, nestedRan)
+		require.NoError(t, <-txErr)
+	})
+
+	t.Run("CancelsAfterDeadline", func(t *testing.T) {
+		b := gormBackend(t, getSqlite(t))
+		shutdowner := b.Manager.(txmanager.Shutdowner)
+
+		began := make(chan struct{})
+		txErr := make(chan error, 1)
+		l := &recordingListener{}
+		go func() {
+			txErr <- b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+				if err := b.Insert(ctx, "a"); err != nil {
+					return err
+				}
+				close(began)
+				<-ctx.Done()
+				// the rollback takes a while
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			}, txmanager.WithListeners(l))
+		}()
+		<-began
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.Equal(t, context.DeadlineExceeded, shutdowner.Shutdown(ctx))
+		// Shutdown returns once the cancelled transaction rolled back
+		require.Equal(t, []string{"begin", "cancel", "rollback"}, l.Events())
+		require.True(t, errors.Is(<-txErr, context.Canceled))
+
+		exists, err := b.Exists("a")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		txManager := txmanager.Chain(txmanager.NewTxManager(nopDriver{}), txmanager.Timeout(time.Second))
+		require.NoError(t, txManager.(txmanager.Shutdowner).Shutdown(context.Background()))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		})
+		require.Equal(t, txmanager.ErrManagerClosed, err)
+	})
+
+	t.Run("InOtherManagerTransaction", func(t *testing.T) {
+		closed := txmanager.NewTxManager(&flakyDriver{})
+		require.NoError(t, closed.(txmanager.Shutdowner).Shutdown(context.Background()))
+
+		ran := false
+		err := txmanager.NewTxManager(&flakyDriver{}).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return closed.WithTransaction(ctx, func(ctx context.Context) error {
+				ran = true
+				return nil
+			})
+		})
+		require.Equal(t, txmanager.ErrManagerClosed, err)
+		require.False(t, ran)
+	})
+}
@@ -0,0 +1,112 @@
+package txmanager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SlowTx describes a transaction still open past its slow threshold
+type SlowTx struct {
+	// Info is the TxInfo of the transaction, its Duration is the elapsed time
+	Info TxInfo
+	// Stack is where WithTransaction was called
+	Stack string
+	// Statements are the statements run in the transaction so far, the last 100
+	// of a longer transaction
+	Statements []Entry
+	// DroppedStatements is the number of statements run before Statements
+	DroppedStatements int
+}
+
+// statementLimit bounds the statements kept by a transaction for DetectSlow
+// and DiagnoseLocks, a long batch would otherwise grow without limit
+const statementLimit = 100
+
+// SlowTxHandler is called, from its own goroutine, with a transaction still
+// open past the slow threshold
+type SlowTxHandler func(ctx context.Context, tx SlowTx)
+
+// DetectSlow calls handler when a top-level transaction is still open after
+// threshold, while it runs, so that long-held locks show up before the
+// transaction ends. A nil handler logs a warning with logrus.
+func DetectSlow(threshold time.Duration, handler SlowTxHandler) Option {
+	return func(c *config) {
+		c.slowThreshold = threshold
+		c.slowHandler = handler
+	}
+}
+
+// LogSlow returns a SlowTxHandler logging a warning to logger
+func LogSlow(logger logrus.FieldLogger) SlowTxHandler {
+	return func(_ context.Context, tx SlowTx) {
+		statements := make([]string, len(tx.Statements))
+		for i, e := range tx.Statements {
+			statements[i] = e.String()
+		}
+		logger.WithFields(logrus.Fields{
+			"tx_name":            tx.Info.Name,
+			"elapsed":            tx.Info.Duration,
+			"stack":              tx.Stack,
+			"statements":         statements,
+			"dropped_statements": tx.DroppedStatements,
+		}).Warn("slow transaction still open")
+	}
+}
+
+// watchSlow calls the slow handler of a top-level transaction once it is open
+// past the threshold, the returned func stops watching
+func (s *txState) watchSlow(ctx context.Context) (stop func()) {
+	cfg := s.config
+	if cfg.slowThreshold <= 0 || s.parent != nil {
+		return func() {}
+	}
+	handler := cfg.slowHandler
+	if handler == nil {
+		handler = LogSlow(logrus.StandardLogger())
+	}
+
+	timer := time.AfterFunc(cfg.slowThreshold, func() {
+		handler(ctx, SlowTx{
+			Info:              s.snapshot(),
+			Stack:             formatStack(s.callers),
+			Statements:        s.statements.Entries(),
+			DroppedStatements: s.statements.Dropped(),
+		})
+	})
+	return func() { timer.Stop() }
+}
+
+// callers returns the program counters of the caller of WithTransaction,
+// formatted only when needed with formatStack
+func callers() []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+// pkgPath is the import path of this package, with its major version
+var pkgPath = reflect.TypeOf(txState{}).PkgPath()
+
+// formatStack formats pcs, leaving out the frames of this package
+func formatStack(pcs []uintptr) string {
+	if len(pcs) == 0 {
+		return ""
+	}
+	frames := runtime.CallersFrames(pcs)
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPath+".") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package txmanager_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectSlow(t *testing.T) {
+	t.Run("FiresWhileOpen", func(t *testing.T) {
+		slow := make(chan txmanager.SlowTx, 2)
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.DetectSlow(50*time.Millisecond, func(_ context.Context, tx txmanager.SlowTx) {
+			slow <- tx
+		}), txmanager.Nested())
+
+		var got txmanager.SlowTx
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).AutoMigrate(&record{}))
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error)
+			// nested transactions do not report on their own
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				got = <-slow
+				return nil
+			})
+			require.NoError(t, err)
+			return nil
+		}, txmanager.Name("slow"))
+		require.NoError(t, err)
+
+		require.Equal(t, "slow", got.Info.Name)
+		require.True(t, got.Info.Duration >= 50*time.Millisecond)
+		require.True(t, strings.Contains(got.Stack, "TestDetectSlow"), got.Stack)
+		require.False(t, strings.Contains(got.Stack, ".runTx"), got.Stack)
+
+		var statements []string
+		for _, e := range got.Statements {
+			statements = append(statements, e.String())
+		}
+		require.Contains(t, statements, "INSERT INTO `records` (`key`) VALUES (?) [a]")
+		require.Contains(t, statements, "SAVEPOINT txmanager_sp_1")
+		require.Len(t, slow, 0)
+	})
+
+	t.Run("KeepsLastStatements", func(t *testing.T) {
+		slow := make(chan txmanager.SlowTx, 1)
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		txManager := txmanager.NewGormTxManager(db, txmanager.DetectSlow(50*time.Millisecond, func(_ context.Context, tx txmanager.SlowTx) {
+			slow <- tx
+		}))
+
+		var got txmanager.SlowTx
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			for i := 0; i < 150; i++ {
+				require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: strconv.Itoa(i)}).Error)
+			}
+			got = <-slow
+			return nil
+		})
+		require.NoError(t, err)
+
+		// a long batch keeps the last 100 statements
+		require.Len(t, got.Statements, 100)
+		require.Equal(t, 51, got.DroppedStatements)
+		require.Equal(t, "INSERT INTO `records` (`key`) VALUES (?) [149]", got.Statements[99].String())
+	})
+
+	t.Run("NotFiredWhenFast", func(t *testing.T) {
+		slow := make(chan txmanager.SlowTx, 1)
+		txManager := txmanager.NewGormTxManager(getSqliteV2(t), txmanager.DetectSlow(50*time.Millisecond, func(_ context.Context, tx txmanager.SlowTx) {
+			slow <- tx
+		}))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		})
+		require.NoError(t, err)
+
+		select {
+		case tx := <-slow:
+			t.Fatalf("unexpected slow transaction %+v", tx)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
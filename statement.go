@@ -0,0 +1,179 @@
+package txmanager
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	gormv2 "gorm.io/gorm"
+)
+
+// openConns maps the connection of every open managed transaction to the state
+// of its innermost level, so that statements run on it can be traced back
+var openConns sync.Map
+
+// connTx is implemented by the Tx of the built-in drivers, conn returns the
+// connection their statements run on
+type connTx interface {
+	conn() interface{}
+}
+
+// statementDriver is implemented by the built-in drivers, captureStatements
+// installs the callbacks reporting statements to their transaction
+type statementDriver interface {
+	captureStatements()
+}
+
+// CaptureStatements installs the gorm callbacks reporting the statements of the
+// transactions when the manager is created, for Record, DiagnoseLocks and the
+// ReadOnly check passed to WithTransaction only. The managers created with
+// Record, DetectSlow, DiagnoseLocks, TrackCallers or ReadOnly install them
+// already. gorm does not allow callbacks to be registered while the db is in
+// use, create the managers before serving.
+func CaptureStatements() Option {
+	return func(c *config) {
+		c.capture = true
+	}
+}
+
+// captures reports whether the manager needs the statements of its transactions
+func (c config) captures() bool {
+	return c.capture || len(c.recorders) > 0 || c.slowThreshold > 0 || c.diagnoseLocks || c.trackCallers || c.readOnly
+}
+
+func connState(conn interface{}) *txState {
+	if conn == nil {
+		return nil
+	}
+	if c, ok := conn.(*txConn); ok {
+		// gorm v1 handles bound to a ctx share the *sql.Tx
+		conn = c.tx
+	}
+	if state, ok := openConns.Load(conn); ok {
+		return state.(*txState)
+	}
+	return nil
+}
+
+// statement reports a statement run in the transaction
+func (s *txState) statement(sql string, vars []interface{}) {
+	sql = strings.TrimSpace(sql)
+	root := s.root()
+	root.mu.Lock()
+	root.lastStatement = sql
+	root.mu.Unlock()
+
+	if len(s.recorders()) == 0 {
+		return
+	}
+	s.record(Entry{Kind: EntryStatement, SQL: sql, Vars: append([]interface{}(nil), vars...)})
+}
+
+// record adds e to the recorders of the transaction and of its parents
+func (s *txState) record(e Entry) {
+	e.Tx = s.info.Name
+	for _, r := range s.recorders() {
+		r.add(e)
+	}
+}
+
+// recorders returns the recorders of the transaction and of its parents, once each
+func (s *txState) recorders() []*Recorder {
+	var recorders []*Recorder
+	seen := map[*Recorder]bool{}
+	for level := s; level != nil; level = level.parent {
+		if level.statements != nil {
+			recorders = append(recorders, level.statements)
+		}
+		for _, r := range level.config.recorders {
+			if !seen[r] {
+				seen[r] = true
+				recorders = append(recorders, r)
+			}
+		}
+	}
+	return recorders
+}
+
+const statementCallback = "txmanager:statement"
+
+var (
+	// callbacksMu guards callback registration, gorm does not allow it concurrently
+	callbacksMu sync.Mutex
+	// captured holds the pools, or gorm v2 callbacks, already capturing statements
+	captured sync.Map
+)
+
+// captureOnce runs register once per key
+func captureOnce(key interface{}, register func()) {
+	if _, ok := captured.Load(key); ok {
+		return
+	}
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	if _, ok := captured.Load(key); ok {
+		return
+	}
+	register()
+	captured.Store(key, true)
+}
+
+func (d gormDriver) captureStatements() {
+	captureOnce(d.pool(), d.registerCallbacks)
+}
+
+func (d gormDriver) registerCallbacks() {
+	cb := d.db.Callback()
+	if cb.Create().Get(statementCallback) != nil {
+		return
+	}
+	cb.Create().Before("gorm:create").Register(readOnlyCallback, gormReadOnly)
+	cb.Update().Before("gorm:update").Register(readOnlyCallback, gormReadOnly)
+	cb.Delete().Before("gorm:delete").Register(readOnlyCallback, gormReadOnly)
+	cb.Create().After("gorm:create").Register(statementCallback, gormStatement)
+	cb.Query().After("gorm:query").Register(statementCallback, gormStatement)
+	cb.RowQuery().After("gorm:row_query").Register(statementCallback, gormStatement)
+	cb.Update().After("gorm:update").Register(statementCallback, gormStatement)
+	cb.Delete().After("gorm:delete").Register(statementCallback, gormStatement)
+}
+
+func gormStatement(scope *gorm.Scope) {
+	if state := connState(scope.SQLDB()); state != nil {
+		state.statement(scope.SQL, scope.SQLVars)
+	}
+}
+
+func (d gormV2Driver) captureStatements() {
+	captureOnce(d.db.Callback(), d.registerCallbacks)
+}
+
+func (d gormV2Driver) registerCallbacks() {
+	cb := d.db.Callback()
+	if cb.Create().Get(statementCallback) != nil {
+		return
+	}
+	cb.Create().Before("gorm:create").Register(readOnlyCallback, gormV2ReadOnly)
+	cb.Update().Before("gorm:update").Register(readOnlyCallback, gormV2ReadOnly)
+	cb.Delete().Before("gorm:delete").Register(readOnlyCallback, gormV2ReadOnly)
+	cb.Raw().Before("gorm:raw").Register(readOnlyCallback, gormV2ReadOnly)
+	cb.Create().After("gorm:create").Register(statementCallback, gormV2Statement)
+	cb.Query().After("gorm:query").Register(statementCallback, gormV2Statement)
+	cb.Row().After("gorm:row").Register(statementCallback, gormV2Statement)
+	cb.Raw().After("gorm:raw").Register(statementCallback, gormV2Statement)
+	cb.Update().After("gorm:update").Register(statementCallback, gormV2Statement)
+	cb.Delete().After("gorm:delete").Register(statementCallback, gormV2Statement)
+}
+
+func gormV2Statement(db *gormv2.DB) {
+	if state := connState(db.Statement.ConnPool); state != nil {
+		state.statement(db.Statement.SQL.String(), db.Statement.Vars)
+	}
+}
+
+func (t gormTx) conn() interface{} {
+	if c, ok := t.db.CommonDB().(*txConn); ok {
+		return c.tx
+	}
+	return t.db.CommonDB()
+}
+func (t gormV2Tx) conn() interface{} { return t.db.Statement.ConnPool }
@@ -0,0 +1,133 @@
+package txmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TimeoutTx is implemented by a Tx able to make the database itself abort the
+// statements of the transaction running past a time limit
+type TimeoutTx interface {
+	Tx
+	// SetTimeout limits the statements and lock waits of the transaction to d,
+	// a zero d restores the database defaults before the transaction ends
+	SetTimeout(d time.Duration) error
+}
+
+// DeadlineError is returned by WithTransaction when the transaction ran longer
+// than its MaxDuration. It unwraps to the error that ended the transaction,
+// usually context.DeadlineExceeded or the error of a statement killed by the
+// database.
+type DeadlineError struct {
+	Name        string
+	MaxDuration time.Duration
+	Err         error
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("txmanager: transaction %q exceeded its max duration of %s: %v", e.Name, e.MaxDuration, e.Err)
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return e.Err
+}
+
+// MaxDuration cancels the transaction when it runs longer than d. The built-in
+// drivers also ask the database to abort statements and lock waits running past
+// d, on MySQL with max_execution_time and innodb_lock_wait_timeout, on Postgres
+// with statement_timeout and lock_timeout.
+func MaxDuration(d time.Duration) Option {
+	return func(c *config) {
+		c.maxDuration = d
+	}
+}
+
+// setTimeout applies the server-side limits of a top-level transaction
+func (s *txState) setTimeout(d time.Duration) {
+	t, ok := s.tx.(TimeoutTx)
+	if !ok || s.parent != nil {
+		return
+	}
+	// restored even when only some of the limits were set
+	s.timeout = true
+	if err := t.SetTimeout(d); err != nil {
+		logrus.Warnf("txmanager: cannot set the timeout of transaction %q, only the ctx deadline applies: %v", s.info.Name, err)
+	}
+}
+
+// clearTimeout restores the server-side limits before the transaction ends, the
+// connection goes back to the pool afterwards. The limits of a transaction on a
+// pinned connection are restored by unpin once it ended, they could not be
+// restored here when the transaction was aborted with its ctx.
+func (s *txState) clearTimeout() {
+	if !s.timeout || s.pinned != nil {
+		return
+	}
+	s.timeout = false
+	err := s.tx.(TimeoutTx).SetTimeout(0)
+	if errors.Is(err, sql.ErrTxDone) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// aborted with its ctx: SET LOCAL limits ended with the transaction, and
+		// the MySQL ones are only set here in a session, which discards its connection
+		return
+	}
+	if err != nil {
+		logrus.Warnf("txmanager: cannot restore the timeout of transaction %q: %v", s.info.Name, err)
+	}
+}
+
+// timeoutStatements returns the statements limiting a transaction to d on the
+// given dialect, or restoring the defaults when d is zero
+func timeoutStatements(dialect string, d time.Duration) []string {
+	millis := d.Milliseconds()
+	if d > 0 && millis < 1 {
+		millis = 1
+	}
+	switch dialect {
+	case "mysql":
+		if d == 0 {
+			return []string{
+				"SET SESSION max_execution_time = DEFAULT",
+				"SET SESSION innodb_lock_wait_timeout = DEFAULT",
+			}
+		}
+		// innodb_lock_wait_timeout is in whole seconds, at least 1
+		seconds := (millis + 999) / 1000
+		return []string{
+			fmt.Sprintf("SET SESSION max_execution_time = %d", millis),
+			fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds),
+		}
+	case "postgres":
+		if d == 0 {
+			// SET LOCAL ends with the transaction
+			return nil
+		}
+		return []string{
+			fmt.Sprintf("SET LOCAL statement_timeout = %d", millis),
+			fmt.Sprintf("SET LOCAL lock_timeout = %d", millis),
+		}
+	}
+	return nil
+}
+
+func (t gormTx) SetTimeout(d time.Duration) error {
+	for _, stmt := range timeoutStatements(t.db.Dialect().GetName(), d) {
+		if err := t.db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t gormV2Tx) SetTimeout(d time.Duration) error {
+	for _, stmt := range timeoutStatements(t.db.Dialector.Name(), d) {
+		if err := t.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,11 @@ package txmanager
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
@@ -10,6 +15,145 @@ import (
 
 const TxConnKey = "txConn"
 
+// ErrRollback can be returned by a TxFn to roll the transaction back without
+// failing, WithTransaction then returns nil
+var ErrRollback = errors.New("txmanager: rollback requested")
+
+// txState is the state of a managed transaction, stored in its ctx
+type txState struct {
+	info         TxInfo
+	config       config
+	driver       Driver
+	tx           Tx
+	conn         interface{}
+	parent       *txState
+	savepoint    string
+	timeout      bool
+	pinned       *sql.Conn
+	session      *session
+	dialect      string
+	vars         []string
+	statements   *Recorder
+	goroutine    int64
+	callers      []uintptr
+	savepoints   int64
+	rollbackOnly int32
+
+	mu            sync.Mutex
+	commitHooks   []func(ctx context.Context)
+	lastStatement string
+}
+
+// snapshot returns the TxInfo of the transaction as of now
+func (s *txState) snapshot() TxInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := s.info
+	info.Duration = time.Since(info.StartTime)
+	info.CommitHooks = len(s.commitHooks)
+	return info
+}
+
+// root returns the state of the top-level transaction
+func (s *txState) root() *txState {
+	for s.parent != nil {
+		s = s.parent
+	}
+	return s
+}
+
+func (s *txState) addCommitHooks(fns ...func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commitHooks = append(s.commitHooks, fns...)
+}
+
+// committed runs the commit hooks of a top-level transaction, or hands them to
+// the parent transaction of a released savepoint
+func (s *txState) committed(ctx context.Context) {
+	s.mu.Lock()
+	hooks := s.commitHooks
+	s.commitHooks = nil
+	s.mu.Unlock()
+
+	if s.parent != nil {
+		s.parent.addCommitHooks(hooks...)
+		return
+	}
+	for _, hook := range hooks {
+		runCommitHook(ctx, hook)
+	}
+}
+
+func runCommitHook(ctx context.Context, hook func(ctx context.Context)) {
+	defer func() {
+		if p := recover(); p != nil {
+			logrus.Errorf("commit hook panic: %v, stack trace: %s", p, printStackTrace())
+		}
+	}()
+	hook(ctx)
+}
+
+type txStateKey struct{}
+
+func getTxState(ctx context.Context) *txState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(txStateKey{}).(*txState)
+	return state
+}
+
+func setTxState(ctx context.Context, state *txState) context.Context {
+	return context.WithValue(ctx, txStateKey{}, state)
+}
+
+// Info returns the transaction managed in ctx, with Duration set to the time
+// elapsed since it began. It returns false when ctx is not in a transaction.
+func Info(ctx context.Context) (TxInfo, bool) {
+	state := getTxState(ctx)
+	if state == nil {
+		return TxInfo{}, false
+	}
+	return state.snapshot(), true
+}
+
+// InTransaction reports whether ctx is in a transaction started by a TxManager
+func InTransaction(ctx context.Context) bool {
+	return getTxState(ctx) != nil
+}
+
+// SetRollbackOnly marks the transaction in ctx so that it is rolled back instead
+// of committed when the TxFn returns, WithTransaction then returns nil
+func SetRollbackOnly(ctx context.Context) {
+	state := getTxState(ctx)
+	if state == nil {
+		logrus.Warn("SetRollbackOnly called outside of a transaction")
+		return
+	}
+	atomic.StoreInt32(&state.rollbackOnly, 1)
+}
+
+// AfterCommit registers fn to run once the transaction in ctx is committed, it
+// is dropped if the transaction is rolled back. Outside of a transaction fn runs
+// immediately. A panic in fn is recovered and logged.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	state := getTxState(ctx)
+	if state == nil {
+		runCommitHook(ctx, fn)
+		return
+	}
+	state.addCommitHooks(fn)
+}
+
+// IsRollbackOnly reports whether the transaction in ctx was marked with SetRollbackOnly
+func IsRollbackOnly(ctx context.Context) bool {
+	state := getTxState(ctx)
+	return state != nil && atomic.LoadInt32(&state.rollbackOnly) == 1
+}
+
+// GetTxConn returns the transaction carried by ctx, nil outside of a transaction.
+// The handle is bound to ctx, a running statement is aborted when ctx is done.
 func GetTxConn(ctx context.Context) *gorm.DB {
 	if ctx == nil {
 		return nil
@@ -25,13 +169,44 @@ func GetTxConn(ctx context.Context) *gorm.DB {
 		logrus.Warnf("Invalid type, want: *gorm.DB got: %T", ctxVal)
 		return nil
 	}
+	if conn, ok := dbConn.CommonDB().(*txConn); ok {
+		return withCommonDB(dbConn, &txConn{ctx: ctx, tx: conn.tx})
+	}
 	return dbConn
 }
 
+// txConn adapts a *sql.Tx to the gorm v1 connection interfaces, running its
+// statements with ctx since gorm v1 does not pass one
+type txConn struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+func (c *txConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.tx.ExecContext(c.ctx, query, args...)
+}
+
+func (c *txConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.tx.PrepareContext(c.ctx, query)
+}
+
+func (c *txConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.tx.QueryContext(c.ctx, query, args...)
+}
+
+func (c *txConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.tx.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *txConn) Commit() error   { return c.tx.Commit() }
+func (c *txConn) Rollback() error { return c.tx.Rollback() }
+
 func setTxConn(ctx context.Context, db *gorm.DB) context.Context {
 	return context.WithValue(ctx, TxConnKey, db)
 }
 
+// GetTxConnV2 returns the transaction carried by ctx bound to ctx, nil outside of
+// a transaction. Its statements are aborted when ctx is done.
 func GetTxConnV2(ctx context.Context) *gormv2.DB {
 	if ctx == nil {
 		return nil
@@ -47,7 +222,8 @@ func GetTxConnV2(ctx context.Context) *gormv2.DB {
 		logrus.Warnf("Invalid type, want: *gormv2.DB got: %T", ctxVal)
 		return nil
 	}
-	return dbConn
+	// bind the statements to ctx so that they are aborted with it
+	return dbConn.WithContext(ctx)
 }
 
 func setTxConnV2(ctx context.Context, db *gormv2.DB) context.Context {
@@ -3,8 +3,9 @@ package txmanager_test
 import (
 	"context"
 	"testing"
+	"time"
 
-	"github.com/shortlyst-ai/go-txmanager"
+	"github.com/shortlyst-ai/go-txmanager/v2"
 	"github.com/stretchr/testify/require"
 )
 
@@ -34,3 +35,54 @@ func TestGetTxConn(t *testing.T) {
 		require.Nil(t, dbv2)
 	})
 }
+
+func TestSetRollbackOnly(t *testing.T) {
+	t.Run("OutsideTransaction", func(t *testing.T) {
+		ctx := context.Background()
+		txmanager.SetRollbackOnly(ctx)
+		require.False(t, txmanager.IsRollbackOnly(ctx))
+	})
+}
+
+func TestInfo(t *testing.T) {
+	t.Run("OutsideTransaction", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), txmanager.TxConnKey, 1)
+		require.False(t, txmanager.InTransaction(ctx))
+
+		_, ok := txmanager.Info(ctx)
+		require.False(t, ok)
+	})
+}
+
+func TestAfterCommit(t *testing.T) {
+	t.Run("OutsideTransaction", func(t *testing.T) {
+		called := false
+		txmanager.AfterCommit(context.Background(), func(ctx context.Context) {
+			called = true
+		})
+		require.True(t, called)
+	})
+}
+
+// softRecord is deleted by setting DeletedAt
+type softRecord struct {
+	Key       string `gorm:"primary_key"`
+	DeletedAt *time.Time
+}
+
+func TestGetTxConnKeepsScopes(t *testing.T) {
+	db := getSqlite(t)
+	require.NoError(t, db.AutoMigrate(&softRecord{}).Error)
+	require.NoError(t, db.Create(&softRecord{Key: "a"}).Error)
+	require.NoError(t, db.Delete(&softRecord{Key: "a"}).Error)
+
+	// the handle of the transaction keeps the scopes of the db of the manager
+	txManager := txmanager.StartTxManager(db.Unscoped())
+	err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+		var count int
+		require.NoError(t, txmanager.GetTxConn(ctx).Model(&softRecord{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+		return nil
+	})
+	require.NoError(t, err)
+}
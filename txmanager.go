@@ -2,9 +2,11 @@ package txmanager
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
@@ -14,111 +16,393 @@ import (
 type TxFn func(ctx context.Context) error
 
 type TxManager interface {
-	WithTransaction(ctx context.Context, txfn TxFn) error
+	WithTransaction(ctx context.Context, txfn TxFn, opts ...Option) error
+}
+
+// Tx is a transaction begun by a Driver
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// Driver begins transactions for a TxManager created with NewTxManager, it is
+// the extension point to support other databases or libraries
+type Driver interface {
+	// Begin begins a transaction and returns ctx carrying its connection. The
+	// TxInfo of the transaction is already available in ctx through Info.
+	Begin(ctx context.Context, opts *sql.TxOptions) (context.Context, Tx, error)
+}
+
+type txManager struct {
+	driver Driver
+	config config
+	gate   *gate
+}
+
+// NewTxManager create TxManager beginning transactions with driver
+func NewTxManager(driver Driver, opts ...Option) TxManager {
+	cfg := newConfig(opts)
+	if d, ok := driver.(statementDriver); ok && cfg.captures() {
+		d.captureStatements()
+	}
+	return &txManager{driver: driver, config: cfg, gate: newGate(cfg)}
+}
+
+func (m *txManager) WithTransaction(parentCtx context.Context, txfn TxFn, opts ...Option) error {
+	cfg := m.config.with(opts)
+	return m.gate.run(parentCtx, cfg.name, joins(parentCtx, cfg, m.driver), func(ctx context.Context) error {
+		return runTx(ctx, cfg, m.driver, txfn)
+	})
+}
+
+func (m *txManager) Shutdown(ctx context.Context) error {
+	return m.gate.shutdown(ctx)
 }
 
 type GormTxManager struct {
-	db *gorm.DB
+	db     *gorm.DB
+	config config
+	gate   *gate
 }
 
 type GormV2TxManager struct {
-	db *gormv2.DB
+	db     *gormv2.DB
+	config config
+	gate   *gate
 }
 
 // StartTxManager create TxManager with db
-func StartTxManager(db *gorm.DB) TxManager {
-	return &GormTxManager{db: db}
+func StartTxManager(db *gorm.DB, opts ...Option) TxManager {
+	cfg := newConfig(opts)
+	if cfg.captures() {
+		gormDriver{db}.captureStatements()
+	}
+	return &GormTxManager{db: db, config: cfg, gate: newGate(cfg)}
 }
 
 // NewGormTxManager create TxManagerGormV2 with dbv2
-func NewGormTxManager(db *gormv2.DB) TxManager {
-	return &GormV2TxManager{db: db}
+func NewGormTxManager(db *gormv2.DB, opts ...Option) TxManager {
+	cfg := newConfig(opts)
+	if cfg.captures() {
+		gormV2Driver{db}.captureStatements()
+	}
+	return &GormV2TxManager{db: db, config: cfg, gate: newGate(cfg)}
 }
 
 // WithTransaction creates a new transaction and handles rollback/commit based on the
 // error object returned by the `TxFn`
-func (g *GormTxManager) WithTransaction(parentCtx context.Context, txfn TxFn) (err error) {
-	tx := g.db.Begin()
-	txCtx := setTxConn(parentCtx, tx)
+func (g *GormTxManager) WithTransaction(parentCtx context.Context, txfn TxFn, opts ...Option) error {
+	cfg := g.config.with(opts)
+	d := gormDriver{g.db}
+	return g.gate.run(parentCtx, cfg.name, joins(parentCtx, cfg, d), func(ctx context.Context) error {
+		return runTx(ctx, cfg, d, txfn)
+	})
+}
 
-	defer func() {
-		if p := recover(); p != nil {
-			// a panic occurred, rollback and repanic
-			tx.Rollback()
-			logrus.Errorf("stack trace: %s", printStackTrace())
-			err = fmt.Errorf("panic recovered: %v", p)
-		} else if err != nil {
-			// error occurred, rollback
-			tx.Rollback()
-		} else {
-			// all good, commit
-			err = tx.Commit().Error
-		}
-	}()
+// Shutdown stops accepting transactions and drains the in-flight ones, see Shutdowner
+func (g *GormTxManager) Shutdown(ctx context.Context) error {
+	return g.gate.shutdown(ctx)
+}
 
-	//execute txfn in background and communicates errors through a channel
-	errCh := make(chan error)
-	go func() {
-		var errFunc error
-		defer func() {
-			if p := recover(); p != nil {
-				logrus.Errorf("stack trace: %s", printStackTrace())
-				errFunc = fmt.Errorf("panic recovered: %v", p)
-			}
-			errCh <- errFunc
-		}()
-		errFunc = txfn(txCtx)
-	}()
+func (g *GormV2TxManager) WithTransaction(parentCtx context.Context, txfn TxFn, opts ...Option) error {
+	cfg := g.config.with(opts)
+	d := gormV2Driver{g.db}
+	return g.gate.run(parentCtx, cfg.name, joins(parentCtx, cfg, d), func(ctx context.Context) error {
+		return runTx(ctx, cfg, d, txfn)
+	})
+}
 
-	//wait for context cancelled or fn is success(err=nil) or failed(err=!nil)
-	select {
-	case <-parentCtx.Done():
-		return parentCtx.Err()
-	case err := <-errCh:
-		return err
+// Shutdown stops accepting transactions and drains the in-flight ones, see Shutdowner
+func (g *GormV2TxManager) Shutdown(ctx context.Context) error {
+	return g.gate.shutdown(ctx)
+}
+
+type gormDriver struct {
+	db *gorm.DB
+}
+
+func (d gormDriver) Begin(ctx context.Context, opts *sql.TxOptions) (context.Context, Tx, error) {
+	db := d.db
+	if s := sessionOf(ctx); s != nil {
+		// begin on the connection of the session
+		db = s.db
+	} else if conn := pinnedConn(ctx); conn != nil {
+		db = withCommonDB(d.db, sessionConn{ctx: ctx, conn: conn})
+	}
+	tx := db.BeginTx(ctx, opts)
+	if tx.Error != nil {
+		return nil, nil, tx.Error
 	}
+	if sqlTx, ok := tx.CommonDB().(*sql.Tx); ok {
+		// bind the statements to ctx
+		setCommonDB(tx, &txConn{ctx: ctx, tx: sqlTx})
+	}
+	return setTxConn(ctx, tx), gormTx{tx}, nil
+}
+
+type gormTx struct {
+	db *gorm.DB
+}
+
+func (t gormTx) Commit() error   { return t.db.Commit().Error }
+func (t gormTx) Rollback() error { return t.db.Rollback().Error }
+
+type gormV2Driver struct {
+	db *gormv2.DB
+}
+
+func (d gormV2Driver) Begin(ctx context.Context, opts *sql.TxOptions) (context.Context, Tx, error) {
+	db := d.db.WithContext(ctx)
+	if s := sessionOf(ctx); s != nil {
+		// begin on the connection of the session
+		db = s.dbV2.WithContext(ctx)
+	} else if conn := pinnedConn(ctx); conn != nil {
+		db.Statement.ConnPool = conn
+	}
+	tx := db.Begin(opts)
+	if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+	return setTxConnV2(ctx, tx), gormV2Tx{tx}, nil
+}
+
+type gormV2Tx struct {
+	db *gormv2.DB
+}
+
+func (t gormV2Tx) Commit() error   { return t.db.Commit().Error }
+func (t gormV2Tx) Rollback() error { return t.db.Rollback().Error }
+
+// PanicError is returned by WithTransaction when the TxFn panicked
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
 }
 
-func (g *GormV2TxManager) WithTransaction(parentCtx context.Context, txfn TxFn) (err error) {
-	tx := g.db.Begin()
-	txCtx := setTxConnV2(parentCtx, tx)
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic recovered: %v", e.Value)
+}
+
+// runTx runs txfn inside a transaction begun by driver, shared by all managers
+func runTx(parentCtx context.Context, cfg config, driver Driver, txfn TxFn) (err error) {
+	callerCtx := parentCtx
+	if cfg.maxDuration > 0 {
+		var cancel context.CancelFunc
+		parentCtx, cancel = context.WithTimeout(parentCtx, cfg.maxDuration)
+		defer cancel()
+	}
+
+	retry := getRetryState(parentCtx)
+	state := &txState{driver: driver, config: cfg, info: TxInfo{
+		Manager:   cfg.manager,
+		Name:      cfg.name,
+		Isolation: cfg.isolation,
+		ReadOnly:  cfg.readOnly,
+		StartTime: time.Now(),
+		Attempt:   retry.attempt,
+	}}
+
+	var (
+		txCtx context.Context
+		tx    Tx
+		// dbErr is the begin or commit error, reported to the circuit breaker
+		dbErr error
+	)
+	ls := listeners(cfg.listeners)
+	if parent := getTxState(parentCtx); nests(parentCtx, cfg, driver) {
+		// already in a transaction of this database, nest it as a savepoint
+		state.parent = parent
+		state.info.Depth = parent.info.Depth + 1
+		state.info.Isolation = parent.info.Isolation
+		state.info.ReadOnly = parent.info.ReadOnly
+		txCtx, tx, err = beginNested(setTxState(parentCtx, state), state)
+	} else {
+		var probe bool
+		if probe, err = cfg.breaker.allow(ls); err != nil {
+			return err
+		}
+		if cfg.breaker != nil {
+			defer func() { cfg.breaker.done(parentCtx, probe, dbErr, ls) }()
+		}
+		if pinErr := state.pin(parentCtx); pinErr != nil {
+			dbErr = pinErr
+			return pinErr
+		}
+		defer state.unpin()
+		txCtx, tx, err = driver.Begin(setTxState(parentCtx, state), cfg.txOptions())
+		dbErr = err
+	}
+	if err != nil {
+		return err
+	}
+	if state.parent == nil && (cfg.slowThreshold > 0 || cfg.diagnoseLocks) {
+		state.statements = &Recorder{Limit: statementLimit}
+	}
+	state.open(tx)
+	defer state.close()
+	if cfg.maxDuration > 0 {
+		state.setTimeout(cfg.maxDuration)
+	}
+	if err = state.setVars(parentCtx); err != nil {
+		state.rollback()
+		return err
+	}
+	// the retry state belongs to this attempt, transactions started by txfn get their own
+	txCtx = context.WithValue(txCtx, retryKey{}, (*retryState)(nil))
+	defer state.watchSlow(txCtx)()
+
+	info := state.snapshot()
+	if retry.attempt > 1 {
+		retryInfo := info
+		retryInfo.Err = retry.lastErr
+		ls.OnRetry(txCtx, retryInfo)
+	}
+	ls.OnBegin(txCtx, info)
 
 	defer func() {
 		if p := recover(); p != nil {
 			// a panic occurred, rollback and repanic
-			tx.Rollback()
 			logrus.Errorf("stack trace: %s", printStackTrace())
-			err = errors.New("panic recovered: " + fmt.Sprintf("%v", p))
-		} else if err != nil {
-			// error occurred, rollback
-			tx.Rollback()
-		} else {
-			// all good, commit
-			err = tx.Commit().Error
+			err = &PanicError{Value: p}
+		}
+		if err != nil && cfg.maxDuration > 0 && callerCtx.Err() == nil && time.Since(state.info.StartTime) >= cfg.maxDuration {
+			var pe *PanicError
+			if !errors.As(err, &pe) {
+				err = &DeadlineError{Name: cfg.name, MaxDuration: cfg.maxDuration, Err: err}
+			}
+		}
+
+		info = state.snapshot()
+		info.Err = err
+		var pe *PanicError
+		switch {
+		case errors.As(err, &pe):
+			ls.OnPanic(txCtx, info)
+		case err != nil && errors.Is(err, parentCtx.Err()):
+			ls.OnCancel(txCtx, info)
+		}
+
+		if err != nil && !IsRollbackOnly(txCtx) && !cfg.shouldRollback(parentCtx, err) {
+			// error matched by NoRollbackFor, commit and still return the error
+			if dbErr = state.commit(); dbErr != nil {
+				err = dbErr
+			} else {
+				retry.committed = true
+			}
+			info.Err = err
+			ls.OnCommit(txCtx, info)
+			if retry.committed {
+				state.committed(parentCtx)
+			}
+			return
 		}
-	}()
 
-	//execute txfn in background and communicates errors through a channel
-	errCh := make(chan error)
-	go func() {
-		var errFunc error
-		defer func() {
-			if p := recover(); p != nil {
-				logrus.Errorf("stack trace: %s", printStackTrace())
-				errFunc = fmt.Errorf("panic recovered: %v", p)
+		if err != nil {
+			// error occurred, rollback
+			state.rollback()
+			ls.OnRollback(txCtx, info)
+			if errors.Is(err, ErrRollback) {
+				err = nil
 			}
-			errCh <- errFunc
-		}()
-		errFunc = txfn(txCtx)
+			err = state.diagnose(err)
+			return
+		}
+
+		if IsRollbackOnly(txCtx) {
+			// marked with SetRollbackOnly, rollback without failing
+			state.rollback()
+			info.Err = ErrRollback
+			ls.OnRollback(txCtx, info)
+			return
+		}
+
+		// all good, commit
+		err = state.commit()
+		dbErr = err
+		info.Err = err
+		ls.OnCommit(txCtx, info)
+		if err == nil {
+			state.committed(parentCtx)
+		}
 	}()
 
-	//wait for context cancelled or fn is success(err=nil) or failed(err=!nil)
-	select {
-	case <-parentCtx.Done():
-		return parentCtx.Err()
-	case err := <-errCh:
-		return err
+	err = txfn(txCtx)
+	if err == nil && parentCtx.Err() != nil {
+		// cancelled while txfn ran, the driver already aborts the transaction
+		err = parentCtx.Err()
+	}
+	return err
+}
+
+// nests reports whether a transaction begun with ctx and cfg on driver is a
+// savepoint of the transaction of ctx
+func nests(ctx context.Context, cfg config, driver Driver) bool {
+	parent := getTxState(ctx)
+	return parent != nil && (cfg.nested || cfg.nest && sameDriver(parent.driver, driver))
+}
+
+// joins reports whether a transaction begun with ctx and cfg on driver runs on
+// the connection already held by ctx, nested in its transaction or begun in its
+// session with no transaction open
+func joins(ctx context.Context, cfg config, driver Driver) bool {
+	if nests(ctx, cfg, driver) {
+		return true
+	}
+	d, ok := driver.(pooledDriver)
+	return ok && getSession(ctx, d.pool()).idle()
+}
+
+// open marks the transaction as begun with tx
+func (s *txState) open(tx Tx) {
+	s.tx = tx
+	s.track()
+	if c, ok := tx.(connTx); ok {
+		s.conn = c.conn()
+	} else if s.parent != nil {
+		s.conn = s.parent.conn
+	}
+	if s.conn != nil {
+		openConns.Store(s.conn, s)
+	}
+	s.mark(EntryBegin, EntrySavepoint)
+}
+
+// close hands the connection back to the parent transaction, if any
+func (s *txState) close() {
+	s.untrack()
+	if s.conn == nil {
+		return
+	}
+	if s.parent != nil {
+		openConns.Store(s.conn, s.parent)
+		return
+	}
+	openConns.Delete(s.conn)
+}
+
+// commit commits the transaction, or releases its savepoint
+func (s *txState) commit() error {
+	s.clearTimeout()
+	s.clearVars()
+	s.mark(EntryCommit, EntryRelease)
+	return s.tx.Commit()
+}
+
+// rollback rolls the transaction back, or back to its savepoint
+func (s *txState) rollback() {
+	s.clearTimeout()
+	s.clearVars()
+	s.mark(EntryRollback, EntryRollbackTo)
+	s.tx.Rollback()
+}
+
+// mark records the marker of a top-level transaction or of a savepoint
+func (s *txState) mark(top, nested EntryKind) {
+	if s.parent == nil {
+		s.record(Entry{Kind: top})
+		return
 	}
+	s.record(Entry{Kind: nested, SQL: s.savepoint})
 }
 
 func printStackTrace() string {
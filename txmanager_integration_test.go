@@ -2,16 +2,24 @@ package txmanager_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	"github.com/shortlyst-ai/go-txmanager"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/dberr"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
 	"github.com/stretchr/testify/require"
 	gormv2 "gorm.io/gorm"
 )
 
 func TestTxManager_WithTransaction(t *testing.T) {
+	skipWithoutMysql(t)
+
 	db := getMysql()
 	db.AutoMigrate(&author{}, &book{}, &authorBook{})
 	defer closeDB(t, db)
@@ -440,4 +448,474 @@ func TestTxManager_WithTransaction(t *testing.T) {
 		require.Nil(t, bookResult.ID)
 
 	})
+
+	t.Run("Listener_NotifiedOnCommitAndRollback", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gorm.DB) {
+			err := resetDB(db)
+			require.NoError(t, err)
+		}(db)
+
+		// start TxManager with a listener
+		listener := &recordingListener{}
+		txManager := txmanager.StartTxManager(db, txmanager.WithListeners(listener))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoAuthor.AddAuthor(ctx, author1)
+			return err
+		}, txmanager.Name("add-author"))
+		require.NoError(t, err)
+
+		// adding the same author again fails on the unique name
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoAuthor.AddAuthor(ctx, author1)
+			return err
+		}, txmanager.Name("add-author"))
+		require.Error(t, err)
+
+		require.Equal(t, []string{"begin", "commit", "begin", "rollback"}, listener.Events())
+		for _, info := range listener.infos {
+			require.Equal(t, "add-author", info.Name)
+			require.Equal(t, 1, info.Attempt)
+			require.False(t, info.StartTime.IsZero())
+		}
+		require.NoError(t, listener.infos[1].Err)
+		require.Error(t, listener.infos[3].Err)
+	})
+
+	t.Run("ListenerV2_NotifiedOnPanic", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gormv2.DB) {
+			err := resetDBV2(db)
+			require.NoError(t, err)
+		}(dbv2)
+
+		// start TxManager with a listener
+		listener := &recordingListener{}
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.WithListeners(listener))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoBook.AddBookV2(ctx, book1)
+			if err != nil {
+				return err
+			}
+			panic("test_panic")
+		})
+		require.Error(t, err)
+		require.Equal(t, []string{"begin", "panic", "rollback"}, listener.Events())
+	})
+
+	t.Run("SetRollbackOnly_ThenRollbackWithoutError", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gorm.DB) {
+			err := resetDB(db)
+			require.NoError(t, err)
+		}(db)
+
+		// start TxManager
+		txManager := txmanager.StartTxManager(db)
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoBook.AddBook(ctx, book1)
+			if err != nil {
+				return err
+			}
+
+			// dry-run, discard the work done so far
+			txmanager.SetRollbackOnly(ctx)
+			require.True(t, txmanager.IsRollbackOnly(ctx))
+			return nil
+		})
+		require.NoError(t, err)
+
+		// validate data
+		var bookResult book
+		err = db.Model(&book{}).First(&bookResult, "name = ?", "Math").Error
+		require.Error(t, err)
+		require.Nil(t, bookResult.ID)
+	})
+
+	t.Run("ErrRollbackV2_ThenRollbackWithoutError", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gormv2.DB) {
+			err := resetDBV2(db)
+			require.NoError(t, err)
+		}(dbv2)
+
+		// start TxManager
+		txManager := txmanager.NewGormTxManager(dbv2)
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoBook.AddBookV2(ctx, book1)
+			if err != nil {
+				return err
+			}
+			return txmanager.ErrRollback
+		})
+		require.NoError(t, err)
+
+		// validate data
+		var bookResult book
+		err = dbv2.Model(&book{}).First(&bookResult, "name = ?", "Math").Error
+		require.Error(t, err)
+		require.Nil(t, bookResult.ID)
+	})
+
+	t.Run("NoRollbackFor_ThenCommitAndReturnError", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gorm.DB) {
+			err := resetDB(db)
+			require.NoError(t, err)
+		}(db)
+
+		errProcessed := errors.New("already processed")
+
+		// start TxManager, errProcessed still commits the work done so far
+		txManager := txmanager.StartTxManager(db, txmanager.NoRollbackFor(errProcessed))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoBook.AddBook(ctx, book1)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("book 1: %w", errProcessed)
+		})
+		require.True(t, errors.Is(err, errProcessed))
+
+		// validate data
+		var bookResult book
+		err = db.Model(&book{}).First(&bookResult, "name = ?", "Math").Error
+		require.NoError(t, err)
+		require.Equal(t, book1.Name, bookResult.Name)
+	})
+
+	t.Run("RollbackOnV2_OverridesNoRollbackFor", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gormv2.DB) {
+			err := resetDBV2(db)
+			require.NoError(t, err)
+		}(dbv2)
+
+		errProcessed := errors.New("already processed")
+		errFatal := errors.New("fatal")
+
+		// start TxManager
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.NoRollbackFor(errProcessed))
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoBook.AddBookV2(ctx, book1)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: %v", errProcessed, errFatal)
+		}, txmanager.RollbackOn(func(err error) bool {
+			return strings.Contains(err.Error(), errFatal.Error())
+		}))
+		require.True(t, errors.Is(err, errProcessed))
+
+		// validate data
+		var bookResult book
+		err = dbv2.Model(&book{}).First(&bookResult, "name = ?", "Math").Error
+		require.Error(t, err)
+		require.Nil(t, bookResult.ID)
+	})
+
+	t.Run("InfoV2_DescribesCurrentTransaction", func(t *testing.T) {
+		// start TxManager
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.ManagerName("library"))
+
+		require.False(t, txmanager.InTransaction(context.Background()))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.True(t, txmanager.InTransaction(ctx))
+
+			info, ok := txmanager.Info(ctx)
+			require.True(t, ok)
+			require.Equal(t, "library", info.Manager)
+			require.Equal(t, "read-books", info.Name)
+			require.Equal(t, 0, info.Depth)
+			require.Equal(t, 1, info.Attempt)
+			require.Equal(t, sql.LevelReadCommitted, info.Isolation)
+			require.False(t, info.StartTime.IsZero())
+			return nil
+		}, txmanager.Name("read-books"), txmanager.Isolation(sql.LevelReadCommitted))
+		require.NoError(t, err)
+	})
+
+	t.Run("NestedV2_RollbackToSavepoint", func(t *testing.T) {
+		// reset db after test
+		defer func(db *gormv2.DB) {
+			err := resetDBV2(db)
+			require.NoError(t, err)
+		}(dbv2)
+
+		// start TxManager
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.Nested())
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := repoAuthor.AddAuthorV2(ctx, author1)
+			if err != nil {
+				return err
+			}
+
+			// the nested transaction is a savepoint, its failure only discards the book
+			err = txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				info, ok := txmanager.Info(ctx)
+				require.True(t, ok)
+				require.Equal(t, 1, info.Depth)
+
+				_, err := repoBook.AddBookV2(ctx, book1)
+				if err != nil {
+					return err
+				}
+				return errors.New("discard book")
+			})
+			require.Error(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// validate data
+		var authorResult author
+		err = dbv2.Model(&author{}).First(&authorResult, "name = ?", "john").Error
+		require.NoError(t, err)
+
+		var bookResult book
+		err = dbv2.Model(&book{}).First(&bookResult, "name = ?", "Math").Error
+		require.Error(t, err)
+		require.Nil(t, bookResult.ID)
+	})
+
+	t.Run("Isolate_RollbackAfterTest", func(t *testing.T) {
+		t.Run("Isolated", func(t *testing.T) {
+			ctx, txManager := txmanagertest.Isolate(t, db)
+
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				_, err := repoAuthor.AddAuthor(ctx, author1)
+				return err
+			})
+			require.NoError(t, err)
+
+			// visible inside the isolated transaction
+			var authorResult author
+			err = txmanager.GetTxConn(ctx).Model(&author{}).First(&authorResult, "name = ?", "john").Error
+			require.NoError(t, err)
+		})
+
+		// validate data is rolled back once the isolated test ended
+		var authorResult author
+		err := db.Model(&author{}).First(&authorResult, "name = ?", "john").Error
+		require.Error(t, err)
+		require.Nil(t, authorResult.ID)
+	})
+
+	t.Run("DeadlineAbortsStatementV2", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(dbv2)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			var slept int
+			return txmanager.GetTxConnV2(ctx).Raw("SELECT SLEEP(5)").Row().Scan(&slept)
+		})
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 5*time.Second, "the statement was not aborted")
+	})
+
+	t.Run("DeadlineAbortsStatement", func(t *testing.T) {
+		txManager := txmanager.StartTxManager(db)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			var slept int
+			return txmanager.GetTxConn(ctx).Raw("SELECT SLEEP(5)").Row().Scan(&slept)
+		})
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 5*time.Second, "the statement was not aborted")
+	})
+
+	t.Run("MaxDuration_ServerSideLimits", func(t *testing.T) {
+		var maxExecutionTime, lockWaitTimeout int
+		txManager := txmanager.StartTxManager(db, txmanager.MaxDuration(1500*time.Millisecond))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConn(ctx).
+				Raw("SELECT @@SESSION.max_execution_time, @@SESSION.innodb_lock_wait_timeout").
+				Row().Scan(&maxExecutionTime, &lockWaitTimeout)
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1500, maxExecutionTime)
+		require.Equal(t, 2, lockWaitTimeout)
+
+		txManagerV2 := txmanager.NewGormTxManager(dbv2, txmanager.MaxDuration(time.Second))
+		err = txManagerV2.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConnV2(ctx).
+				Raw("SELECT @@SESSION.max_execution_time, @@SESSION.innodb_lock_wait_timeout").
+				Row().Scan(&maxExecutionTime, &lockWaitTimeout)
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1000, maxExecutionTime)
+		require.Equal(t, 1, lockWaitTimeout)
+	})
+
+	t.Run("DiagnoseLocks_Deadlock", func(t *testing.T) {
+		require.NoError(t, db.Exec("CREATE TABLE IF NOT EXISTS deadlock_rows (id INT PRIMARY KEY, v INT)").Error)
+		defer db.Exec("DROP TABLE deadlock_rows")
+		require.NoError(t, db.Exec("INSERT INTO deadlock_rows VALUES (1, 0), (2, 0)").Error)
+
+		txManager := txmanager.StartTxManager(db, txmanager.DiagnoseLocks())
+		locked := make(chan struct{}, 2)
+		update := func(first, second int) <-chan error {
+			done := make(chan error, 1)
+			go func() {
+				done <- txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+					if err := txmanager.GetTxConn(ctx).Exec("UPDATE deadlock_rows SET v = v + 1 WHERE id = ?", first).Error; err != nil {
+						return err
+					}
+					locked <- struct{}{}
+					// wait until both transactions hold their first row
+					for len(locked) < 2 {
+						time.Sleep(time.Millisecond)
+					}
+					return txmanager.GetTxConn(ctx).Exec("UPDATE deadlock_rows SET v = v + 1 WHERE id = ?", second).Error
+				})
+			}()
+			return done
+		}
+		errA, errB := update(1, 2), update(2, 1)
+
+		var lockErr *txmanager.LockError
+		for _, err := range []error{<-errA, <-errB} {
+			if errors.As(err, &lockErr) {
+				break
+			}
+		}
+		require.NotNil(t, lockErr, "expected a *txmanager.LockError")
+		require.Contains(t, lockErr.Diagnostics, "WE ROLL BACK TRANSACTION")
+	})
+
+	t.Run("Savepoint_NameLimit", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(dbv2)
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			err := txmanager.Savepoint(ctx, strings.Repeat("s", 65))
+			require.True(t, errors.Is(err, txmanager.ErrInvalidSavepointName))
+			return txmanager.Savepoint(ctx, strings.Repeat("s", 64))
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("SessionVars_ClearedBeforeReuse", func(t *testing.T) {
+		sqlDB, err := dbv2.DB()
+		require.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		defer sqlDB.SetMaxOpenConns(0)
+
+		txManager := txmanager.NewGormTxManager(dbv2)
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			var tenant sql.NullString
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Raw("SELECT @tenant_id").Row().Scan(&tenant))
+			require.Equal(t, "42", tenant.String)
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 42}))
+		require.NoError(t, err)
+
+		// the next user of the connection does not see the variable
+		var tenant sql.NullString
+		require.NoError(t, dbv2.Raw("SELECT @tenant_id").Row().Scan(&tenant))
+		require.False(t, tenant.Valid)
+	})
+
+	t.Run("ReadOnly_RejectedByServer", func(t *testing.T) {
+		txManager := txmanager.StartTxManager(db)
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			// raw gorm v1 statements skip the callbacks, MySQL rejects them
+			return txmanager.GetTxConn(ctx).Exec("DELETE FROM authors").Error
+		}, txmanager.ReadOnly())
+		require.True(t, dberr.IsReadOnlyViolation(err), err)
+	})
+
+	t.Run("MaxDuration_RestoredAfterCancel", func(t *testing.T) {
+		sqlDB, err := dbv2.DB()
+		require.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		defer sqlDB.SetMaxOpenConns(0)
+
+		var defaultTime int
+		require.NoError(t, dbv2.Raw("SELECT @@SESSION.max_execution_time").Row().Scan(&defaultTime))
+
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.MaxDuration(200*time.Millisecond))
+		err = txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+		// the next user of the pool gets the defaults
+		var maxExecutionTime int
+		require.NoError(t, dbv2.Raw("SELECT @@SESSION.max_execution_time").Row().Scan(&maxExecutionTime))
+		require.Equal(t, defaultTime, maxExecutionTime)
+	})
+
+	t.Run("SessionVars_ClearedAfterCancel", func(t *testing.T) {
+		sqlDB, err := dbv2.DB()
+		require.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		defer sqlDB.SetMaxOpenConns(0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		txManager := txmanager.NewGormTxManager(dbv2)
+		err = txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			cancel()
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 42}))
+		require.True(t, errors.Is(err, context.Canceled), err)
+
+		var tenant sql.NullString
+		require.NoError(t, dbv2.Raw("SELECT @tenant_id").Row().Scan(&tenant))
+		require.False(t, tenant.Valid)
+	})
+
+	t.Run("SessionVars_IndependentTransactionInside", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(dbv2)
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&author{Name: stringPointer("pinned")}).Error)
+
+			// the independent transaction begins on another connection
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				var tenant sql.NullString
+				require.NoError(t, txmanager.GetTxConnV2(ctx).Raw("SELECT @tenant_id").Row().Scan(&tenant))
+				require.False(t, tenant.Valid)
+				return nil
+			})
+			require.NoError(t, err)
+			return txmanager.ErrRollback
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 42}))
+		require.NoError(t, err)
+
+		// the outer transaction was not committed by the inner one
+		var count int64
+		require.NoError(t, dbv2.Model(&author{}).Where("name = ?", "pinned").Count(&count).Error)
+		require.Equal(t, int64(0), count)
+	})
+
+	t.Run("SearchPath_FailsOnMysql", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(dbv2, txmanager.SearchPath("tenant_a"))
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			t.Fatal("expected the transaction not to run")
+			return nil
+		})
+		require.EqualError(t, err, "txmanager: SearchPath is only supported on postgres, not mysql")
+	})
+
+	t.Run("SessionVars_PrefixedName", func(t *testing.T) {
+		txManager := txmanager.NewGormTxManager(dbv2)
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			var tenant int
+			require.NoError(t, txmanager.GetTxConnV2(ctx).Raw("SELECT @app.tenant_id").Row().Scan(&tenant))
+			require.Equal(t, 42, tenant)
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"app.tenant_id": 42}))
+		require.NoError(t, err)
+	})
 }
@@ -0,0 +1,169 @@
+package txmanagertest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+)
+
+// Fault is a failure injected by ChaosTxManager into a transaction
+type Fault int
+
+const (
+	// NoFault runs the transaction untouched
+	NoFault Fault = iota
+	// BeginFault fails the transaction before it begins with ErrChaosBegin
+	BeginFault
+	// CommitFault runs the TxFn then fails the commit with ErrChaosCommit, the
+	// transaction is rolled back
+	CommitFault
+	// CommitHangFault runs the TxFn then hangs the commit until the ctx is done,
+	// the transaction is rolled back
+	CommitHangFault
+	// DeadlockFault runs the TxFn then fails the transaction with the
+	// DeadlockErr of the ChaosTxManager, the transaction is rolled back
+	DeadlockFault
+	// AmbiguousCommitFault commits the transaction but returns ErrAmbiguousCommit,
+	// as when the connection is lost while committing
+	AmbiguousCommitFault
+)
+
+var (
+	ErrChaosBegin      = errors.New("txmanagertest: chaos: begin failed")
+	ErrChaosCommit     = errors.New("txmanagertest: chaos: commit failed")
+	ErrAmbiguousCommit = errors.New("txmanagertest: chaos: commit outcome unknown")
+)
+
+// errInjected makes the base TxManager roll back a transaction failed by a fault
+var errInjected = errors.New("txmanagertest: chaos: injected fault")
+
+// ChaosTxManager decorates a TxManager to inject faults into its transactions.
+// Faults are taken from the script first, then drawn with their probability.
+type ChaosTxManager struct {
+	// DeadlockErr is the error returned by DeadlockFault, a MySQL deadlock by default
+	DeadlockErr error
+	// Latency, if set, is spent inside every transaction before its TxFn runs
+	Latency time.Duration
+
+	base txmanager.TxManager
+
+	mu            sync.Mutex
+	rand          *rand.Rand
+	script        []Fault
+	probabilities map[Fault]float64
+	injected      []Fault
+}
+
+// NewChaosTxManager create ChaosTxManager decorating base, seed makes the faults
+// drawn with probabilities reproducible
+func NewChaosTxManager(base txmanager.TxManager, seed int64) *ChaosTxManager {
+	return &ChaosTxManager{
+		DeadlockErr: &mysql.MySQLError{
+			Number:  1213,
+			Message: "Deadlock found when trying to get lock; try restarting transaction",
+		},
+		base:          base,
+		rand:          rand.New(rand.NewSource(seed)),
+		probabilities: map[Fault]float64{},
+	}
+}
+
+// Script queues faults injected one per transaction, in order, before any
+// fault is drawn with probabilities
+func (c *ChaosTxManager) Script(faults ...Fault) *ChaosTxManager {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = append(c.script, faults...)
+	return c
+}
+
+// Probability injects fault in a transaction with probability p, between 0 and 1
+func (c *ChaosTxManager) Probability(fault Fault, p float64) *ChaosTxManager {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probabilities[fault] = p
+	return c
+}
+
+// Injected returns the fault injected in every transaction so far, in order
+func (c *ChaosTxManager) Injected() []Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Fault(nil), c.injected...)
+}
+
+func (c *ChaosTxManager) next() Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fault := NoFault
+	if len(c.script) > 0 {
+		fault, c.script = c.script[0], c.script[1:]
+	} else {
+		// draw in a fixed order so that a seed always gives the same faults
+		draw := c.rand.Float64()
+		for f := BeginFault; f <= AmbiguousCommitFault; f++ {
+			if draw < c.probabilities[f] {
+				fault = f
+				break
+			}
+			draw -= c.probabilities[f]
+		}
+	}
+	c.injected = append(c.injected, fault)
+	return fault
+}
+
+func (c *ChaosTxManager) WithTransaction(ctx context.Context, txfn txmanager.TxFn, opts ...txmanager.Option) error {
+	fault := c.next()
+	if fault == BeginFault {
+		return ErrChaosBegin
+	}
+
+	err := c.base.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := c.sleep(ctx, c.Latency); err != nil {
+			return err
+		}
+		if err := txfn(ctx); err != nil {
+			return err
+		}
+
+		switch fault {
+		case CommitFault:
+			return errInjected
+		case CommitHangFault:
+			<-ctx.Done()
+			return ctx.Err()
+		case DeadlockFault:
+			return c.DeadlockErr
+		}
+		return nil
+	}, opts...)
+
+	switch {
+	case errors.Is(err, errInjected):
+		return ErrChaosCommit
+	case err == nil && fault == AmbiguousCommitFault:
+		return ErrAmbiguousCommit
+	}
+	return err
+}
+
+func (c *ChaosTxManager) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
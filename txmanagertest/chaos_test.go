@@ -0,0 +1,88 @@
+package txmanagertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaosTxManager(t *testing.T) {
+	noop := func(ctx context.Context) error {
+		return nil
+	}
+
+	t.Run("Script", func(t *testing.T) {
+		base := txmanagertest.NewFakeTxManager()
+		chaos := txmanagertest.NewChaosTxManager(base, 1).Script(
+			txmanagertest.BeginFault,
+			txmanagertest.CommitFault,
+			txmanagertest.DeadlockFault,
+			txmanagertest.AmbiguousCommitFault,
+		)
+
+		err := chaos.WithTransaction(context.Background(), noop)
+		require.Equal(t, txmanagertest.ErrChaosBegin, err)
+		base.AssertNotCalled(t)
+
+		err = chaos.WithTransaction(context.Background(), noop)
+		require.Equal(t, txmanagertest.ErrChaosCommit, err)
+		base.AssertRolledBack(t)
+
+		err = chaos.WithTransaction(context.Background(), noop)
+		var mysqlErr *mysql.MySQLError
+		require.True(t, errors.As(err, &mysqlErr))
+		require.Equal(t, uint16(1213), mysqlErr.Number)
+		base.AssertRolledBack(t)
+
+		err = chaos.WithTransaction(context.Background(), noop)
+		require.Equal(t, txmanagertest.ErrAmbiguousCommit, err)
+		base.AssertCommitted(t)
+
+		// script exhausted and no probabilities, no more faults
+		err = chaos.WithTransaction(context.Background(), noop)
+		require.NoError(t, err)
+		base.AssertCommitted(t)
+	})
+
+	t.Run("CommitHang", func(t *testing.T) {
+		base := txmanagertest.NewFakeTxManager()
+		chaos := txmanagertest.NewChaosTxManager(base, 1).Script(txmanagertest.CommitHangFault)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := chaos.WithTransaction(ctx, noop)
+		require.Equal(t, context.DeadlineExceeded, err)
+		base.AssertRolledBack(t)
+	})
+
+	t.Run("Probability", func(t *testing.T) {
+		run := func(seed int64) []txmanagertest.Fault {
+			chaos := txmanagertest.NewChaosTxManager(txmanagertest.NewFakeTxManager(), seed).
+				Probability(txmanagertest.CommitFault, 0.5)
+			for i := 0; i < 20; i++ {
+				_ = chaos.WithTransaction(context.Background(), noop)
+			}
+			return chaos.Injected()
+		}
+
+		faults := run(42)
+		require.Contains(t, faults, txmanagertest.CommitFault)
+		require.Contains(t, faults, txmanagertest.NoFault)
+		require.Equal(t, faults, run(42))
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		chaos := txmanagertest.NewChaosTxManager(txmanagertest.NewFakeTxManager(), 1)
+		chaos.Latency = 20 * time.Millisecond
+
+		start := time.Now()
+		err := chaos.WithTransaction(context.Background(), noop)
+		require.NoError(t, err)
+		require.True(t, time.Since(start) >= chaos.Latency)
+	})
+}
@@ -0,0 +1,305 @@
+package txmanagertest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+)
+
+// Backend is what RunConformance needs to exercise a TxManager
+type Backend struct {
+	// Manager is the TxManager under test
+	Manager txmanager.TxManager
+	// Insert stores key through the transaction carried by ctx
+	Insert func(ctx context.Context, key string) error
+	// Exists reports whether key was committed, reading outside of any transaction
+	Exists func(key string) (bool, error)
+}
+
+// Factory returns a Backend with no data, it is called once per scenario
+type Factory func(t *testing.T) Backend
+
+// RunConformance runs the scenarios every TxManager must pass, covering commit,
+// rollback, panic, cancellation, rollback rules, savepoints with Nested, commit hooks and
+// listeners. Adapters run it against their own Backend.
+func RunConformance(t *testing.T, factory Factory) {
+	errFailed := errors.New("conformance: failed")
+
+	t.Run("Commit", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return b.Insert(ctx, "a")
+		})
+		requireNoError(t, err)
+		requireExists(t, b, "a", true)
+	})
+
+	t.Run("ErrorThenRollback", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if err != errFailed {
+			t.Fatalf("expected %v, got %v", errFailed, err)
+		}
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("PanicThenRollback", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			panic("conformance: panic")
+		})
+		var pe *txmanager.PanicError
+		if !errors.As(err, &pe) || pe.Value != "conformance: panic" {
+			t.Fatalf("expected *txmanager.PanicError, got %v", err)
+		}
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("ContextCancelledThenRollback", func(t *testing.T) {
+		b := factory(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := b.Manager.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			cancel()
+			<-ctx.Done()
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("SetRollbackOnly", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			txmanager.SetRollbackOnly(ctx)
+			return nil
+		})
+		requireNoError(t, err)
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("ErrRollback", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			return txmanager.ErrRollback
+		})
+		requireNoError(t, err)
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("NoRollbackFor", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			return errFailed
+		}, txmanager.NoRollbackFor(errFailed))
+		if err != errFailed {
+			t.Fatalf("expected %v, got %v", errFailed, err)
+		}
+		requireExists(t, b, "a", true)
+	})
+
+	t.Run("SetRollbackOnlyOverNoRollbackFor", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			txmanager.SetRollbackOnly(ctx)
+			return errFailed
+		}, txmanager.NoRollbackFor(errFailed))
+		if err != errFailed {
+			t.Fatalf("expected %v, got %v", errFailed, err)
+		}
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("NestedRollbackToSavepoint", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := b.Insert(ctx, "a"); err != nil {
+				return err
+			}
+			err := b.Manager.WithTransaction(ctx, func(ctx context.Context) error {
+				if info, _ := txmanager.Info(ctx); info.Depth != 1 {
+					t.Errorf("expected depth 1, got %d", info.Depth)
+				}
+				if err := b.Insert(ctx, "b"); err != nil {
+					return err
+				}
+				return errFailed
+			}, txmanager.Nested())
+			if err != errFailed {
+				t.Errorf("expected %v, got %v", errFailed, err)
+			}
+			return nil
+		})
+		requireNoError(t, err)
+		requireExists(t, b, "a", true)
+		requireExists(t, b, "b", false)
+	})
+
+	t.Run("NestedThenOuterRollback", func(t *testing.T) {
+		b := factory(t)
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			err := b.Manager.WithTransaction(ctx, func(ctx context.Context) error {
+				return b.Insert(ctx, "a")
+			}, txmanager.Nested())
+			if err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if err != errFailed {
+			t.Fatalf("expected %v, got %v", errFailed, err)
+		}
+		requireExists(t, b, "a", false)
+	})
+
+	t.Run("AfterCommit", func(t *testing.T) {
+		b := factory(t)
+		var hooks []string
+		register := func(ctx context.Context, name string) {
+			txmanager.AfterCommit(ctx, func(context.Context) {
+				hooks = append(hooks, name)
+			})
+		}
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			register(ctx, "committed")
+			return nil
+		})
+		requireNoError(t, err)
+
+		err = b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			register(ctx, "rolled back")
+			return errFailed
+		})
+		if err != errFailed {
+			t.Fatalf("expected %v, got %v", errFailed, err)
+		}
+
+		if len(hooks) != 1 || hooks[0] != "committed" {
+			t.Fatalf("expected only the committed hook to run, got %v", hooks)
+		}
+	})
+
+	t.Run("Listener", func(t *testing.T) {
+		b := factory(t)
+		l := &eventListener{}
+
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		}, txmanager.WithListeners(l), txmanager.Name("conformance"))
+		requireNoError(t, err)
+
+		_ = b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return errFailed
+		}, txmanager.WithListeners(l), txmanager.Name("conformance"))
+
+		want := []string{"begin conformance", "commit conformance", "begin conformance", "rollback conformance"}
+		if got := l.get(); !equalStrings(got, want) {
+			t.Fatalf("expected events %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		b := factory(t)
+		if txmanager.InTransaction(context.Background()) {
+			t.Fatal("expected no transaction outside of WithTransaction")
+		}
+		err := b.Manager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			info, ok := txmanager.Info(ctx)
+			if !ok || info.Name != "conformance" || info.Depth != 0 || info.Attempt != 1 {
+				t.Errorf("unexpected info %+v, in transaction: %v", info, ok)
+			}
+			return nil
+		}, txmanager.Name("conformance"))
+		requireNoError(t, err)
+	})
+}
+
+func requireNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func requireExists(t *testing.T, b Backend, key string, want bool) {
+	t.Helper()
+	got, err := b.Exists(key)
+	requireNoError(t, err)
+	if got != want {
+		t.Fatalf("expected %q to exist: %v, got %v", key, want, got)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// eventListener records the begin/commit/rollback events it receives
+type eventListener struct {
+	txmanager.NopTxListener
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventListener) add(event string, info txmanager.TxInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event+" "+info.Name)
+}
+
+func (l *eventListener) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func (l *eventListener) OnBegin(_ context.Context, info txmanager.TxInfo) {
+	l.add("begin", info)
+}
+
+func (l *eventListener) OnCommit(_ context.Context, info txmanager.TxInfo) {
+	l.add("commit", info)
+}
+
+func (l *eventListener) OnRollback(_ context.Context, info txmanager.TxInfo) {
+	l.add("rollback", info)
+}
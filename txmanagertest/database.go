@@ -0,0 +1,83 @@
+package txmanagertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	gormv2 "gorm.io/gorm"
+)
+
+// Record is the row the scenarios of RunConformanceGorm and
+// RunConformanceGormV2 insert, in the txmanagertest_records table
+type Record struct {
+	Key string `gorm:"primary_key;primaryKey"`
+}
+
+func (Record) TableName() string { return "txmanagertest_records" }
+
+// RunConformanceGorm runs RunConformance on the managers created with
+// StartTxManager(db, opts...), db being a handle on a database of the caller.
+// Before every scenario setup creates the txmanagertest_records table of
+// Record, AutoMigrate is used when setup is nil. The table is dropped once the
+// scenario ended.
+func RunConformanceGorm(t *testing.T, db *gorm.DB, setup func(db *gorm.DB) error, opts ...txmanager.Option) {
+	if setup == nil {
+		setup = func(db *gorm.DB) error {
+			return db.AutoMigrate(&Record{}).Error
+		}
+	}
+	RunConformance(t, func(t *testing.T) Backend {
+		if err := setup(db); err != nil {
+			t.Fatalf("txmanagertest: set up the schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := db.DropTableIfExists(&Record{}).Error; err != nil {
+				t.Errorf("txmanagertest: drop the table: %v", err)
+			}
+		})
+		return Backend{
+			Manager: txmanager.StartTxManager(db, opts...),
+			Insert: func(ctx context.Context, key string) error {
+				return txmanager.GetTxConn(ctx).Create(&Record{Key: key}).Error
+			},
+			Exists: func(key string) (bool, error) {
+				var count int
+				err := db.Model(&Record{}).Where(&Record{Key: key}).Count(&count).Error
+				return count > 0, err
+			},
+		}
+	})
+}
+
+// RunConformanceGormV2 is RunConformanceGorm for gorm v2, the managers are
+// created with NewGormTxManager(db, opts...)
+func RunConformanceGormV2(t *testing.T, db *gormv2.DB, setup func(db *gormv2.DB) error, opts ...txmanager.Option) {
+	if setup == nil {
+		setup = func(db *gormv2.DB) error {
+			return db.AutoMigrate(&Record{})
+		}
+	}
+	RunConformance(t, func(t *testing.T) Backend {
+		if err := setup(db); err != nil {
+			t.Fatalf("txmanagertest: set up the schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := db.Migrator().DropTable(&Record{}); err != nil {
+				t.Errorf("txmanagertest: drop the table: %v", err)
+			}
+		})
+		return Backend{
+			Manager: txmanager.NewGormTxManager(db, opts...),
+			Insert: func(ctx context.Context, key string) error {
+				return txmanager.GetTxConnV2(ctx).Create(&Record{Key: key}).Error
+			},
+			Exists: func(key string) (bool, error) {
+				var count int64
+				err := db.Model(&Record{}).Where(&Record{Key: key}).Count(&count).Error
+				return count > 0, err
+			},
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Package txmanagertest provides helpers to test code using txmanager.
+package txmanagertest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+)
+
+// Outcome is how a transaction run by FakeTxManager ended
+type Outcome string
+
+const (
+	Committed    Outcome = "committed"
+	RolledBack   Outcome = "rolled back"
+	BeginFailed  Outcome = "begin failed"
+	CommitFailed Outcome = "commit failed"
+)
+
+// Call records one WithTransaction call on a FakeTxManager
+type Call struct {
+	Name    string
+	Outcome Outcome
+	// Err is the error returned by WithTransaction
+	Err error
+	// Panic is the value the TxFn panicked with, nil if it did not panic
+	Panic interface{}
+	// CommitHooks is the number of hooks registered with txmanager.AfterCommit
+	CommitHooks int
+}
+
+// FakeTxManager is a TxManager without database. It runs the TxFn with the same
+// commit, rollback, panic and ctx-cancel semantics as the gorm managers, and
+// records every call.
+type FakeTxManager struct {
+	// BeginErr, if set, is returned when beginning a transaction
+	BeginErr error
+	// CommitErr, if set, is returned when committing a transaction
+	CommitErr error
+
+	manager txmanager.TxManager
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewFakeTxManager create FakeTxManager with the given manager options
+func NewFakeTxManager(opts ...txmanager.Option) *FakeTxManager {
+	f := &FakeTxManager{}
+	f.manager = txmanager.NewTxManager(fakeDriver{f}, opts...)
+	return f
+}
+
+func (f *FakeTxManager) WithTransaction(ctx context.Context, txfn txmanager.TxFn, opts ...txmanager.Option) error {
+	rec := &callRecorder{commitErr: f.CommitErr, call: Call{Outcome: BeginFailed}}
+	opts = append(opts[:len(opts):len(opts)], txmanager.WithListeners(rec))
+	err := f.manager.WithTransaction(ctx, txfn, opts...)
+
+	rec.mu.Lock()
+	call := rec.call
+	rec.mu.Unlock()
+	call.Err = err
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	return err
+}
+
+// Calls returns the calls recorded so far, oldest first
+func (f *FakeTxManager) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// LastCall returns the most recent call, false if there was none
+func (f *FakeTxManager) LastCall() (Call, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.calls) == 0 {
+		return Call{}, false
+	}
+	return f.calls[len(f.calls)-1], true
+}
+
+// Shutdown rejects new calls with txmanager.ErrManagerClosed and drains the
+// in-flight ones, as the gorm managers do
+func (f *FakeTxManager) Shutdown(ctx context.Context) error {
+	return f.manager.(txmanager.Shutdowner).Shutdown(ctx)
+}
+
+// Reset forgets the recorded calls
+func (f *FakeTxManager) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// AssertCommitted fails t if the last call was not committed
+func (f *FakeTxManager) AssertCommitted(t testing.TB) {
+	t.Helper()
+	f.assertOutcome(t, Committed)
+}
+
+// AssertRolledBack fails t if the last call was not rolled back
+func (f *FakeTxManager) AssertRolledBack(t testing.TB) {
+	t.Helper()
+	f.assertOutcome(t, RolledBack)
+}
+
+// AssertNotCalled fails t if WithTransaction was called
+func (f *FakeTxManager) AssertNotCalled(t testing.TB) {
+	t.Helper()
+	if calls := f.Calls(); len(calls) > 0 {
+		t.Errorf("txmanagertest: expected no transaction, got %d", len(calls))
+	}
+}
+
+func (f *FakeTxManager) assertOutcome(t testing.TB, want Outcome) {
+	t.Helper()
+	call, ok := f.LastCall()
+	if !ok {
+		t.Errorf("txmanagertest: expected a %s transaction, got none", want)
+		return
+	}
+	if call.Outcome != want {
+		t.Errorf("txmanagertest: expected transaction %q to be %s, got %s (err: %v)", call.Name, want, call.Outcome, call.Err)
+	}
+}
+
+type fakeDriver struct {
+	f *FakeTxManager
+}
+
+func (d fakeDriver) Begin(ctx context.Context, opts *sql.TxOptions) (context.Context, txmanager.Tx, error) {
+	if d.f.BeginErr != nil {
+		return nil, nil, d.f.BeginErr
+	}
+	return ctx, fakeTx{commitErr: d.f.CommitErr}, nil
+}
+
+type fakeTx struct {
+	commitErr error
+}
+
+func (t fakeTx) Commit() error                { return t.commitErr }
+func (t fakeTx) Rollback() error              { return nil }
+func (t fakeTx) Savepoint(name string) error  { return nil }
+func (t fakeTx) RollbackTo(name string) error { return nil }
+func (t fakeTx) Release(name string) error    { return nil }
+
+// callRecorder fills a Call from the lifecycle of its transaction
+type callRecorder struct {
+	txmanager.NopTxListener
+	commitErr error
+
+	mu   sync.Mutex
+	call Call
+}
+
+func (r *callRecorder) OnBegin(_ context.Context, info txmanager.TxInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.call.Name = info.Name
+}
+
+func (r *callRecorder) OnCommit(_ context.Context, info txmanager.TxInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.call.Outcome = Committed
+	if r.commitErr != nil && errors.Is(info.Err, r.commitErr) {
+		r.call.Outcome = CommitFailed
+	}
+	r.call.CommitHooks = info.CommitHooks
+}
+
+func (r *callRecorder) OnRollback(_ context.Context, info txmanager.TxInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.call.Outcome = RolledBack
+	r.call.CommitHooks = info.CommitHooks
+}
+
+func (r *callRecorder) OnPanic(_ context.Context, info txmanager.TxInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pe *txmanager.PanicError
+	if errors.As(info.Err, &pe) {
+		r.call.Panic = pe.Value
+	}
+}
@@ -0,0 +1,177 @@
+package txmanagertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTxManager(t *testing.T) {
+	t.Run("Commit", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+
+		hookCalled := false
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			require.True(t, txmanager.InTransaction(ctx))
+			txmanager.AfterCommit(ctx, func(ctx context.Context) {
+				hookCalled = true
+			})
+			return nil
+		}, txmanager.Name("create-order"))
+		require.NoError(t, err)
+		require.True(t, hookCalled)
+
+		txManager.AssertCommitted(t)
+		require.Equal(t, []txmanagertest.Call{{
+			Name:        "create-order",
+			Outcome:     txmanagertest.Committed,
+			CommitHooks: 1,
+		}}, txManager.Calls())
+	})
+
+	t.Run("ErrorThenRollback", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+		errFailed := errors.New("failed")
+
+		hookCalled := false
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			txmanager.AfterCommit(ctx, func(ctx context.Context) {
+				hookCalled = true
+			})
+			return errFailed
+		})
+		require.Equal(t, errFailed, err)
+		require.False(t, hookCalled)
+
+		txManager.AssertRolledBack(t)
+		call, ok := txManager.LastCall()
+		require.True(t, ok)
+		require.Equal(t, errFailed, call.Err)
+		require.Equal(t, 1, call.CommitHooks)
+	})
+
+	t.Run("PanicThenRollback", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			panic("boom")
+		})
+		require.Error(t, err)
+
+		var pe *txmanager.PanicError
+		require.True(t, errors.As(err, &pe))
+		txManager.AssertRolledBack(t)
+		call, _ := txManager.LastCall()
+		require.Equal(t, "boom", call.Panic)
+	})
+
+	t.Run("ContextCancelledThenRollback", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			cancel()
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+		require.Equal(t, context.Canceled, err)
+		txManager.AssertRolledBack(t)
+	})
+
+	t.Run("SetRollbackOnly", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			txmanager.SetRollbackOnly(ctx)
+			return nil
+		})
+		require.NoError(t, err)
+		txManager.AssertRolledBack(t)
+	})
+
+	t.Run("BeginFailed", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+		txManager.BeginErr = errors.New("connection refused")
+
+		called := false
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			called = true
+			return nil
+		})
+		require.Equal(t, txManager.BeginErr, err)
+		require.False(t, called)
+
+		call, _ := txManager.LastCall()
+		require.Equal(t, txmanagertest.BeginFailed, call.Outcome)
+	})
+
+	t.Run("CommitFailed", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+		txManager.CommitErr = errors.New("connection lost")
+
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		})
+		require.Equal(t, txManager.CommitErr, err)
+
+		call, _ := txManager.LastCall()
+		require.Equal(t, txmanagertest.CommitFailed, call.Outcome)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+
+		hookCalled := false
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				info, _ := txmanager.Info(ctx)
+				require.Equal(t, 1, info.Depth)
+				txmanager.AfterCommit(ctx, func(ctx context.Context) {
+					hookCalled = true
+				})
+				return nil
+			}, txmanager.Name("inner"), txmanager.Nested())
+		}, txmanager.Name("outer"))
+		require.NoError(t, err)
+		require.True(t, hookCalled)
+
+		calls := txManager.Calls()
+		require.Len(t, calls, 2)
+		require.Equal(t, "inner", calls[0].Name)
+		require.Equal(t, "outer", calls[1].Name)
+		require.Equal(t, 1, calls[1].CommitHooks)
+	})
+
+	t.Run("IndependentWithoutNested", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+
+		errFailed := errors.New("failed")
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+				info, _ := txmanager.Info(ctx)
+				require.Equal(t, 0, info.Depth)
+				return nil
+			}, txmanager.Name("inner"))
+			require.NoError(t, err)
+			return errFailed
+		}, txmanager.Name("outer"))
+		require.Equal(t, errFailed, err)
+
+		// the inner transaction committed on its own
+		calls := txManager.Calls()
+		require.Len(t, calls, 2)
+		require.Equal(t, txmanagertest.Committed, calls[0].Outcome)
+		require.Equal(t, txmanagertest.RolledBack, calls[1].Outcome)
+	})
+
+	t.Run("NotCalled", func(t *testing.T) {
+		txManager := txmanagertest.NewFakeTxManager()
+		txManager.AssertNotCalled(t)
+	})
+}
@@ -0,0 +1,35 @@
+package txmanagertest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateGoldenEnv is the environment variable that makes AssertGolden rewrite
+// the golden files instead of comparing them
+const UpdateGoldenEnv = "TXMANAGER_UPDATE_GOLDEN"
+
+// AssertGolden fails t when got differs from the content of the golden file at
+// path, typically the Transcript of a txmanager.Recorder. The file is written
+// with got when UpdateGoldenEnv is set.
+func AssertGolden(t testing.TB, path string, got string) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("txmanagertest: cannot create golden dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("txmanagertest: cannot write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("txmanagertest: cannot read golden file, run with %s=1 to create it: %v", UpdateGoldenEnv, err)
+	}
+	if string(want) != got {
+		t.Errorf("transcript differs from %s, run with %s=1 to update it\n--- want\n%s--- got\n%s", path, UpdateGoldenEnv, want, got)
+	}
+}
@@ -0,0 +1,86 @@
+package txmanagertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	gormv2 "gorm.io/gorm"
+)
+
+// Isolate begins a transaction on db that is rolled back when t ends. The
+// returned ctx carries that transaction, and every WithTransaction of the
+// returned TxManager runs as a savepoint inside it, so tests leave no data
+// behind and can run with t.Parallel(). On SQLite the parallel tests wait for
+// each other, each isolated transaction holds the write lock. Queries must go
+// through the ctx, a query on db itself runs outside of the isolated
+// transaction.
+func Isolate(t testing.TB, db *gorm.DB) (context.Context, txmanager.TxManager) {
+	t.Helper()
+	return isolate(t, txmanager.StartTxManager(db, txmanager.Nested()))
+}
+
+// IsolateV2 is Isolate for gorm v2
+func IsolateV2(t testing.TB, db *gormv2.DB) (context.Context, txmanager.TxManager) {
+	t.Helper()
+	return isolate(t, txmanager.NewGormTxManager(db, txmanager.Nested()))
+}
+
+func isolate(t testing.TB, base txmanager.TxManager) (context.Context, txmanager.TxManager) {
+	t.Helper()
+
+	ready := make(chan context.Context)
+	release := make(chan struct{})
+	done := make(chan error, 1)
+
+	// the outer transaction stays open until its TxFn returns at cleanup
+	go func() {
+		done <- base.WithTransaction(context.Background(), func(ctx context.Context) error {
+			ready <- ctx
+			<-release
+			return txmanager.ErrRollback
+		}, txmanager.Name("txmanagertest.Isolate"))
+	}()
+
+	var txCtx context.Context
+	select {
+	case txCtx = <-ready:
+	case err := <-done:
+		t.Fatalf("txmanagertest: begin isolated transaction: %v", err)
+	}
+
+	t.Cleanup(func() {
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("txmanagertest: rollback isolated transaction: %v", err)
+		}
+	})
+	return txCtx, &isolatedTxManager{base: base, txCtx: txCtx}
+}
+
+// isolatedTxManager nests every transaction in the isolated one
+type isolatedTxManager struct {
+	base  txmanager.TxManager
+	txCtx context.Context
+}
+
+func (m *isolatedTxManager) WithTransaction(ctx context.Context, txfn txmanager.TxFn, opts ...txmanager.Option) error {
+	if !txmanager.InTransaction(ctx) {
+		ctx = isolatedContext{Context: ctx, txCtx: m.txCtx}
+	}
+	return m.base.WithTransaction(ctx, txfn, opts...)
+}
+
+// isolatedContext is ctx seeing the values of the isolated transaction ctx
+type isolatedContext struct {
+	context.Context
+	txCtx context.Context
+}
+
+func (c isolatedContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.txCtx.Value(key)
+}
@@ -0,0 +1,104 @@
+package txmanagertest_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/shortlyst-ai/go-txmanager/v2/txmanagertest"
+	"github.com/stretchr/testify/require"
+	sqlitev2 "gorm.io/driver/sqlite"
+	gormv2 "gorm.io/gorm"
+)
+
+type record struct {
+	Key string `gorm:"primary_key;primaryKey"`
+}
+
+func sqliteDSN(t testing.TB) string {
+	// the isolated transactions take the write lock when they begin, the
+	// parallel tests wait for it
+	return filepath.Join(t.TempDir(), "txmanagertest.db") + "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+}
+
+func TestIsolate(t *testing.T) {
+	db, err := gorm.Open("sqlite3", sqliteDSN(t))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+	require.NoError(t, db.AutoMigrate(&record{}).Error)
+
+	t.Run("Parallel", func(t *testing.T) {
+		for _, name := range []string{"a", "b"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				ctx, txManager := txmanagertest.Isolate(t, db)
+
+				// every test inserts the same key, they do not see each other's data
+				err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					return txmanager.GetTxConn(ctx).Create(&record{Key: "a"}).Error
+				})
+				require.NoError(t, err)
+
+				// a failing transaction only rolls its own work back
+				err = txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					require.NoError(t, txmanager.GetTxConn(ctx).Create(&record{Key: "b"}).Error)
+					return txmanager.ErrRollback
+				})
+				require.NoError(t, err)
+
+				var keys []string
+				require.NoError(t, txmanager.GetTxConn(ctx).Model(&record{}).Pluck("key", &keys).Error)
+				require.Equal(t, []string{"a"}, keys)
+			})
+		}
+	})
+
+	// the tests left no data behind
+	var count int
+	require.NoError(t, db.Model(&record{}).Count(&count).Error)
+	require.Equal(t, 0, count)
+}
+
+func TestIsolateV2(t *testing.T) {
+	db, err := gormv2.Open(sqlitev2.Open(sqliteDSN(t)), &gormv2.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	})
+	require.NoError(t, db.AutoMigrate(&record{}))
+
+	t.Run("Parallel", func(t *testing.T) {
+		for _, name := range []string{"a", "b"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				ctx, txManager := txmanagertest.IsolateV2(t, db)
+
+				err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					return txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error
+				})
+				require.NoError(t, err)
+
+				err = txManager.WithTransaction(ctx, func(ctx context.Context) error {
+					require.NoError(t, txmanager.GetTxConnV2(ctx).Create(&record{Key: "b"}).Error)
+					return txmanager.ErrRollback
+				})
+				require.NoError(t, err)
+
+				var keys []string
+				require.NoError(t, txmanager.GetTxConnV2(ctx).Model(&record{}).Pluck("key", &keys).Error)
+				require.Equal(t, []string{"a"}, keys)
+			})
+		}
+	})
+
+	var count int64
+	require.NoError(t, db.Model(&record{}).Count(&count).Error)
+	require.Equal(t, int64(0), count)
+}
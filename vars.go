@@ -0,0 +1,168 @@
+package txmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VarsTx is implemented by a Tx able to set variables seen by the statements of
+// the transaction, such as a tenant id read by row-level security policies or
+// audit triggers
+type VarsTx interface {
+	Tx
+	// SetVars sets the variables, a nil value clearing a variable that would
+	// outlive the transaction on its connection
+	SetVars(vars map[string]interface{}) error
+}
+
+// VarsFunc returns the variables of a transaction begun with ctx
+type VarsFunc func(ctx context.Context) map[string]interface{}
+
+// SessionVars sets vars right after a top-level transaction begins. The
+// built-in drivers use set_config(name, value, true) on Postgres, as SET LOCAL,
+// and user variables, SET @name, on MySQL, cleared once the transaction ended,
+// even aborted with its ctx, so that they never leak to the next user of the
+// connection, which is discarded when they cannot be cleared. Names are plain
+// identifiers, Postgres ones usually prefixed as in app.tenant_id, read with
+// current_setting('app.tenant_id') on Postgres and @app.tenant_id on MySQL.
+func SessionVars(vars map[string]interface{}) Option {
+	copied := make(map[string]interface{}, len(vars))
+	for name, value := range vars {
+		copied[name] = value
+	}
+	return SessionVarsFrom(func(context.Context) map[string]interface{} {
+		return copied
+	})
+}
+
+// SessionVarsFrom is SessionVars with variables derived from the ctx of
+// WithTransaction, as the tenant or the actor of the request
+func SessionVarsFrom(fn VarsFunc) Option {
+	return func(c *config) {
+		c.vars = append(c.vars, fn)
+	}
+}
+
+// varName matches the variable names safe to splice into a statement
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// setVars sets the variables of a top-level transaction begun with ctx
+func (s *txState) setVars(ctx context.Context) error {
+	if len(s.config.vars) == 0 || s.parent != nil {
+		return nil
+	}
+	vars := map[string]interface{}{}
+	for _, fn := range s.config.vars {
+		for name, value := range fn(ctx) {
+			vars[name] = value
+		}
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	t, ok := s.tx.(VarsTx)
+	if !ok {
+		return fmt.Errorf("txmanager: %T does not support session variables", s.tx)
+	}
+	// cleared even when only some of the variables were set
+	for name := range vars {
+		s.vars = append(s.vars, name)
+	}
+	return t.SetVars(vars)
+}
+
+// clearVars clears the variables before the transaction ends. The variables of
+// a transaction on a pinned connection are cleared by unpin once it ended, they
+// could not be cleared here when the transaction was aborted with its ctx.
+func (s *txState) clearVars() {
+	if len(s.vars) == 0 || s.pinned != nil {
+		return
+	}
+	vars := s.clearedVars()
+	s.vars = nil
+	if err := s.tx.(VarsTx).SetVars(vars); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		logrus.Warnf("txmanager: cannot clear the session variables of transaction %q: %v", s.info.Name, err)
+	}
+}
+
+// clearedVars returns the variables of the transaction set to nil
+func (s *txState) clearedVars() map[string]interface{} {
+	vars := make(map[string]interface{}, len(s.vars))
+	for _, name := range s.vars {
+		vars[name] = nil
+	}
+	return vars
+}
+
+// varsStatements returns the statements and their args setting vars on the given
+// dialect, in the order of the names
+func varsStatements(dialect string, vars map[string]interface{}) ([]string, [][]interface{}, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !varName.MatchString(name) {
+			return nil, nil, fmt.Errorf("txmanager: invalid session variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		stmts []string
+		args  [][]interface{}
+	)
+	for _, name := range names {
+		value := vars[name]
+		switch dialect {
+		case "postgres":
+			if value == nil {
+				// set_config is local to the transaction
+				continue
+			}
+			stmts = append(stmts, "SELECT set_config($1, $2, true)")
+			args = append(args, []interface{}{name, fmt.Sprint(value)})
+		case "mysql":
+			if name == searchPathVar {
+				// a user variable would be silently ignored
+				return nil, nil, fmt.Errorf("txmanager: SearchPath is only supported on postgres, not %s", dialect)
+			}
+			stmts = append(stmts, "SET @"+name+" = ?")
+			args = append(args, []interface{}{value})
+		default:
+			return nil, nil, fmt.Errorf("txmanager: session variables are not supported on %s", dialect)
+		}
+	}
+	return stmts, args, nil
+}
+
+func (t gormTx) SetVars(vars map[string]interface{}) error {
+	stmts, args, err := varsStatements(t.dialect(), vars)
+	if err != nil {
+		return err
+	}
+	for i, stmt := range stmts {
+		if _, err := t.db.CommonDB().Exec(stmt, args[i]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t gormV2Tx) SetVars(vars map[string]interface{}) error {
+	stmts, args, err := varsStatements(t.dialect(), vars)
+	if err != nil {
+		return err
+	}
+	for i, stmt := range stmts {
+		if err := t.exec(stmt, args[i]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
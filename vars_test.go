@@ -0,0 +1,129 @@
+package txmanager_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shortlyst-ai/go-txmanager/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// varsDriver begins transactions recording the variables they set, failing
+// to set them when err is set
+type varsDriver struct {
+	calls *[]map[string]interface{}
+	err   error
+}
+
+func (d varsDriver) Begin(ctx context.Context, _ *sql.TxOptions) (context.Context, txmanager.Tx, error) {
+	return ctx, varsTx{d}, nil
+}
+
+type varsTx struct {
+	varsDriver
+}
+
+func (varsTx) Commit() error   { return nil }
+func (varsTx) Rollback() error { return nil }
+
+func (t varsTx) SetVars(vars map[string]interface{}) error {
+	*t.calls = append(*t.calls, vars)
+	for _, value := range vars {
+		if value != nil {
+			return t.err
+		}
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+func TestSessionVars(t *testing.T) {
+	t.Run("SetThenCleared", func(t *testing.T) {
+		var calls []map[string]interface{}
+		txManager := txmanager.NewTxManager(varsDriver{calls: &calls},
+			txmanager.SessionVars(map[string]interface{}{"app.actor": "system", "app.tenant_id": 0}),
+			txmanager.SessionVarsFrom(func(ctx context.Context) map[string]interface{} {
+				return map[string]interface{}{"app.tenant_id": ctx.Value(tenantKey{})}
+			}))
+
+		ctx := context.WithValue(context.Background(), tenantKey{}, 42)
+		err := txManager.WithTransaction(ctx, func(ctx context.Context) error {
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []map[string]interface{}{
+			{"app.actor": "system", "app.tenant_id": 42},
+			{"app.actor": nil, "app.tenant_id": nil},
+		}, calls)
+	})
+
+	t.Run("ClearedOnRollback", func(t *testing.T) {
+		var calls []map[string]interface{}
+		txManager := txmanager.NewTxManager(varsDriver{calls: &calls})
+		err := txManager.WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txmanager.ErrRollback
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 7}))
+		require.NoError(t, err)
+		require.Equal(t, []map[string]interface{}{{"tenant_id": 7}, {"tenant_id": nil}}, calls)
+	})
+
+	t.Run("ClearedAfterFailure", func(t *testing.T) {
+		var calls []map[string]interface{}
+		errFailed := errors.New("failed")
+		err := txmanager.NewTxManager(varsDriver{calls: &calls, err: errFailed}).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"actor_id": 1, "tenant_id": 7}))
+		require.Equal(t, errFailed, err)
+		// some variables may have been set before the failure
+		require.Equal(t, []map[string]interface{}{
+			{"actor_id": 1, "tenant_id": 7},
+			{"actor_id": nil, "tenant_id": nil},
+		}, calls)
+	})
+
+	t.Run("NoVars", func(t *testing.T) {
+		var calls []map[string]interface{}
+		err := txmanager.NewTxManager(varsDriver{calls: &calls}).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		}, txmanager.SessionVarsFrom(func(context.Context) map[string]interface{} { return nil }))
+		require.NoError(t, err)
+		require.Empty(t, calls)
+	})
+
+	t.Run("UnsupportedDriver", func(t *testing.T) {
+		ran := false
+		err := txmanager.NewTxManager(nopDriver{}).WithTransaction(context.Background(), func(ctx context.Context) error {
+			ran = true
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 7}))
+		require.Error(t, err)
+		require.False(t, ran)
+	})
+
+	t.Run("UnsupportedDialect", func(t *testing.T) {
+		db := getSqliteV2(t)
+		require.NoError(t, db.AutoMigrate(&record{}))
+		err := txmanager.NewGormTxManager(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return txmanager.GetTxConnV2(ctx).Create(&record{Key: "a"}).Error
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id": 7}))
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), "not supported on sqlite"), err.Error())
+
+		var count int64
+		require.NoError(t, db.Model(&record{}).Count(&count).Error)
+		require.Zero(t, count)
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		db := getSqlite(t)
+		err := txmanager.StartTxManager(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+			return nil
+		}, txmanager.SessionVars(map[string]interface{}{"tenant_id = 1; DROP TABLE records; --": 7}))
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), "invalid session variable name"), err.Error())
+	})
+}